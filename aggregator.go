@@ -8,34 +8,94 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
-	"sysvipc"
+	"sync"
 	"time"
 )
 
 type KpiFile struct {
-	last_flush int64
-	counters   map[string]int64
+	schedule       *FlushSchedule
+	interval_start int64 //start of the interval the counters are accumulated in
+	counters       map[string]int64
+	histograms     map[string]*kpiHistogram //histogram kpi oid to the values observed in the interval
+	updated        bool                     //records accumulated since last flush
+	dirty          bool                     //counters changed since last checkpoint
+	last_save      int64                    //time of last checkpoint
+	last           map[string]int64         //rows of the last interval flushed
+	last_end       time.Time
+	flushed_end    int64      //end of the last interval written, checkpointed before the KPI file is published
+	mutex          sync.Mutex //the counters are read by the metrics endpoint
 }
 
 type AlarmFile struct {
-	last_flush int64
+	schedule *FlushSchedule
+	raised   map[string]*AlarmState  //alarm oid to the state of the alarms raised
+	active   map[string]*ActiveAlarm //oid:resource to the alarm raised and not cleared
+	mutex    sync.Mutex
 }
 
-/*Reset and Initial counters map with configured kpi oid as keys and 0 as value*/
+/*the alarms of an oid raised since log_aggregator starts*/
+type AlarmState struct {
+	Oid        string
+	App        string    //app of the last one
+	Level      string    //level of the last one
+	Content    string    //content of the last one
+	LastRaised time.Time //time of the last WARN, ERROR or FATAL record
+	Count      int64     //number of the WARN, ERROR or FATAL records
+	Active     bool      //raised by a WARN, ERROR or FATAL record and not cleared by a CLEAN one since, or by RaiseAlarm for any resource
+}
+
+/*Reset and Initial counters map with configured kpi oid as keys and 0 as value,
+the updown and gauge kpis keep their values*/
 func (self *KpiFile) reset() error {
 	if g_log_cfg == nil {
 		return errors.New("failed to build valid kpi oid map from empty oid map")
 	}
+	last := self.counters
 	self.counters = make(map[string]int64)
-	if g_log_cfg.KpiOid == nil {
-		return nil
-	}
-	for _, v := range g_log_cfg.KpiOid {
+	self.histograms = make(map[string]*kpiHistogram)
+	for _, v := range g_log_cfg.GetKpiOids() {
+		if g_log_cfg.GetKpiKind(v) == KPI_HISTOGRAM {
+			self.histograms[v] = newKpiHistogram(g_log_cfg.GetKpiBuckets(v))
+			continue
+		}
 		self.counters[v] = 0
+		if g_log_cfg.GetKpiKind(v) != KPI_COUNTER {
+			self.counters[v] = last[v]
+		}
 	}
 	return nil
 }
 
+/*Return a copy of the counters accumulated in current interval, with the rows of the histograms by sub-oid*/
+func (self *KpiFile) Counters() map[string]int64 {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.rows()
+}
+
+/*Return the rows written to the KPI file of the last interval flushed, and the end time of the interval*/
+func (self *KpiFile) LastCounters() (map[string]int64, time.Time) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	counters := make(map[string]int64)
+	for k, v := range self.last {
+		counters[k] = v
+	}
+	return counters, self.last_end
+}
+
+/*the rows of the current interval, the mutex shall be held by the caller*/
+func (self *KpiFile) rows() map[string]int64 {
+	counters := make(map[string]int64)
+	for k, v := range self.counters {
+		counters[k] = v
+	}
+	for k, h := range self.histograms {
+		h.rows(k, g_log_cfg.GetKpiBuckets(k), counters)
+	}
+	return counters
+}
+
 /*generate a new KpiFile*/
 func NewKpiFile() (*KpiFile, error) {
 	kc := &KpiFile{}
@@ -43,76 +103,141 @@ func NewKpiFile() (*KpiFile, error) {
 	if err != nil {
 		return nil, err
 	}
+	kc.schedule = NewFlushSchedule(g_log_cfg.KpiInterval, Now())
+	kc.interval_start = kc.schedule.Start().Unix()
 	return kc, nil
 }
 
 /*process kpi record in mq, add up to KpiFile*/
 func (self *KpiFile) Process() error {
-	var str string
-	var i int64
 	for {
 		b, _, err := getKpiRec()
 		if err != nil {
 			break
 		}
-		line := string(b)
 		//Db("KpiFile::Process getKpiRec [%s][%d][%v]", string(b), l, b)
-		sv := strings.Split(line, "|")
-		if sv != nil && len(sv) == 2 {
-			str = sv[0]
-			fmt.Sscanf(sv[1], "%d", &i)
-			c, present := self.counters[str]
-			if present {
-				self.counters[str] = c + i
-			} else {
-				WriteLog(WARN, NO_ALARM, "ProcessKpi encouter unknown oid [%s] [%d]", str, i)
-			}
-		} else {
-			WriteLog(WARN, NO_ALARM, "ProcessKpi encouter invalid record [%s]", line)
-		}
+		self.mutex.Lock()
+		self.apply(string(b))
+		self.mutex.Unlock()
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	now := Now().Unix()
+	if self.dirty && now-self.last_save >= KPI_CHECKPOINT_INTERVAL {
+		return self.checkpoint()
 	}
 	return nil
 }
 
-/* flush KpiFile to file */
-func (self *KpiFile) Flush() error {
-	/*20160514041503|kpi_collector|KPI|1.3.6.1.4.1.193.176.10.2.1.0|360000*/
-	now := time.Now().Unix()
-	if now-self.last_flush >= g_log_cfg.KpiInterval && now%g_log_cfg.KpiInterval < 2 {
-		self.last_flush = now
-
-		filename, err := GenerateFileName("KPI")
-		keys := make([]string, len(self.counters))
-		i := 0
-		for k, _ := range self.counters {
-			keys[i] = k
-			i++
-		}
-		sort.Strings(keys) //sort the oids to get ordered output
-		tmp := filepath.Join(g_log_cfg.AlarmKpiPath, ".kpi.tmp")
-		target := filepath.Join(g_log_cfg.AlarmKpiPath, filename)
-		f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY, 0644)
-		if err != nil {
-			return errors.New(fmt.Sprintf("KpiFile::Flush falied:", err))
+/*apply a kpi record "oid|delta", "oid|=value" or "oid|~value" per the kind of the oid*/
+func (self *KpiFile) apply(line string) {
+	var i int64
+	sv := strings.Split(line, "|")
+	if sv == nil || len(sv) != 2 {
+		WriteLog(WARN, NO_ALARM, "ProcessKpi encouter invalid record [%s]", line)
+		return
+	}
+	str := sv[0]
+	value := sv[1]
+	set := strings.HasPrefix(value, KPI_SET_PREFIX)
+	observe := strings.HasPrefix(value, KPI_OBSERVE_PREFIX)
+	fmt.Sscanf(strings.TrimLeft(value, KPI_SET_PREFIX+KPI_OBSERVE_PREFIX), "%d", &i)
+	if h, present := self.histograms[str]; present {
+		if !observe {
+			WriteLog(WARN, NO_ALARM, "ProcessKpi ignore delta of histogram oid [%s] [%s]", str, value)
+			return
 		}
-		defer func() {
-			f.Close()
-			Info("Flush KPI file [%s]", target)
-			os.Rename(tmp, target)
-		}()
+		h.observe(i, g_log_cfg.GetKpiBuckets(str))
+	} else if c, present := self.counters[str]; !present {
+		WriteLog(WARN, NO_ALARM, "ProcessKpi encouter unknown oid [%s] [%d]", str, i)
+		return
+	} else if observe || (set && g_log_cfg.GetKpiKind(str) == KPI_COUNTER) {
+		WriteLog(WARN, NO_ALARM, "ProcessKpi ignore [%s] of %s oid [%s]", value, g_log_cfg.GetKpiKind(str), str)
+		return
+	} else if set {
+		self.counters[str] = i
+	} else {
+		self.counters[str] = c + i
+	}
+	self.updated = true
+	self.dirty = true
+}
 
-		ts := time.Now().Format("20060102150405")
-		for _, k := range keys {
-			v, present := self.counters[k]
-			if present {
-				f.Write([]byte(fmt.Sprintf("%s|kpi_collector|KPI|%s|%d\n", ts, k, v)))
-			}
+/*Return the end of the current interval, when Flush is due*/
+func (self *KpiFile) NextFlush() time.Time {
+	return self.schedule.Next()
+}
+
+/*Flush the counters to a KPI file stamped with the end of each interval passed,
+the accumulated counters go to the first one and the missed intervals get zero files.
+Every interval due is flushed even if the write of another one fails, the errors are returned together*/
+func (self *KpiFile) Flush() error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	interval := time.Duration(g_log_cfg.KpiInterval) * time.Second
+	due := self.schedule.Due(Now())
+	var errs []string
+	for _, end := range due {
+		err := self.flush(end.Add(-interval), end)
+		if err != nil {
+			errs = append(errs, err.Error())
 		}
-		self.reset()
+	}
+	if len(errs) > 0 {
+		return errors.New(fmt.Sprintf("KpiFile::Flush failed %d of %d intervals: %s", len(errs), len(due), strings.Join(errs, "; ")))
 	}
 	return nil
 }
 
+/* flush KpiFile to file regardless of the interval alignment, e.g. on shutdown.
+In 3gpp format the counters are only checkpointed, a measCollecFile always covers a whole interval */
+func (self *KpiFile) ForceFlush() error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if !self.updated || g_log_cfg.KpiFormat == KPI_FORMAT_3GPP {
+		return self.checkpoint() //restored on startup, no partial file
+	}
+	return self.flush(time.Unix(self.interval_start, 0), Now())
+}
+
+/*write the counters of [begin, end) to KPI file and start over. The state is checkpointed
+before the file is published, a crash in between loses the interval instead of writing it twice*/
+func (self *KpiFile) flush(begin time.Time, end time.Time) error {
+	rows := self.rows()
+	self.last = rows
+	self.last_end = end
+	self.reset()
+	self.updated = false
+	self.interval_start = self.schedule.Start().Unix()
+	self.flushed_end = end.Unix()
+	err := self.checkpoint()
+	if err != nil {
+		WriteLog(WARN, NO_ALARM, "KpiFile::flush %v", err)
+	}
+	return writeKpiFile(rows, begin, end)
+}
+
+/*write the counters measured in [begin, end) to KPI file in the configured format*/
+func writeKpiFile(counters map[string]int64, begin time.Time, end time.Time) error {
+	f := kpiFormatter()
+	b, err := f.Format(counters, begin, end)
+	if err != nil {
+		return errors.New(fmt.Sprintf("KpiFile::Flush falied: %v", err))
+	}
+	//e.g. a partial interval flushed on shutdown, keep it and take the next free name
+	target, err := freeName(g_log_cfg.AlarmKpiPath, func(rc int) (string, error) { return f.FileName(begin, end, rc) })
+	if err != nil {
+		return errors.New(fmt.Sprintf("KpiFile::Flush falied: %v", err))
+	}
+	tmp := filepath.Join(g_log_cfg.AlarmKpiPath, ".kpi.tmp")
+	err = os.WriteFile(tmp, b, 0644)
+	if err != nil {
+		return errors.New(fmt.Sprintf("KpiFile::Flush falied: %v", err))
+	}
+	Info("Flush KPI file [%s]", target)
+	return os.Rename(tmp, target)
+}
+
 /* Process alarm record from MQ, write to tmp file */
 func (self *AlarmFile) Process() error {
 	tmp := filepath.Join(g_log_cfg.AlarmKpiPath, ".alarm.tmp")
@@ -121,51 +246,168 @@ func (self *AlarmFile) Process() error {
 		return errors.New(fmt.Sprintf("ProcessAlarm falied to open tmp file for writing:", err))
 	}
 	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return errors.New(fmt.Sprintf("ProcessAlarm falied to stat tmp file: %v", err))
+	}
+	size := info.Size()
+	formatter := alarmFormatter()
+	_, pipe := formatter.(*pipeAlarmFormatter)
+	changed := false
 	for {
 		b, _, err := getAlarmRec()
 		if err != nil {
 			break
 		}
 		//Db("AlarmFile::Process getAlarmRec [%s][%d][%v]", string(b[0:l]), l, b)
-		f.Write(b)
-		f.Write([]byte("\n"))
+		line := strings.TrimRight(string(b), "\n")
+		var row []byte
+		rec, err := ParseAlarmRecord(line)
+		if err == nil {
+			row = formatter.Row(rec)
+			changed = self.track(rec) || changed
+		} else if pipe {
+			WriteLog(WARN, NO_ALARM, "ProcessAlarm write unchanged: %v", err)
+			row = []byte(line + "\n")
+		} else {
+			//the csv and json WARNING files shall stay parsable
+			WriteLog(WARN, NO_ALARM, "ProcessAlarm quarantine: %v", err)
+			err = quarantineAlarmRecord(line)
+			if err != nil {
+				WriteLog(ERROR, NO_ALARM, "ProcessAlarm failed to quarantine: %v", err)
+			}
+			continue
+		}
+		if size == 0 {
+			n, _ := f.Write(formatter.Header())
+			size += int64(n)
+		}
+		n, _ := f.Write(row)
+		size += int64(n)
 	}
-	return nil
+	if !changed {
+		return nil
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.saveActive()
 }
 
-/* Commit tmp alarm file to alarm interface file */
+/*append the alarm record not parsed to the file under the quarantine dir*/
+func quarantineAlarmRecord(line string) error {
+	dir := filepath.Join(g_log_cfg.AlarmKpiPath, QUARANTINE_DIR)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, QUARANTINE_ALARM_FILE), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(line + "\n")
+	return err
+}
+
+/*track the state of the alarm raised, return true if the active alarms are changed*/
+func (self *AlarmFile) track(rec AlarmRecord) bool {
+	oid := rec.Oid
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.raised == nil {
+		self.raised = make(map[string]*AlarmState)
+	}
+	state, present := self.raised[oid]
+	if !present {
+		state = &AlarmState{Oid: oid}
+		self.raised[oid] = state
+	}
+	state.App = rec.App
+	state.Level = rec.Level
+	state.Content = rec.Content
+	level := Str2Level(rec.Level)
+	if isRaiseLevel(level) {
+		state.LastRaised = rec.Timestamp
+		state.Count++
+	}
+	changed := self.activate(rec)
+	if rec.Stateful {
+		//the ClearAlarm of a resource leaves the oid active if another resource is still raised
+		state.Active = self.activeOid(oid)
+	} else if isRaiseLevel(level) {
+		state.Active = true
+	} else if level == CLEAN {
+		state.Active = false
+	}
+	return changed
+}
+
+/*Return the state of the alarms raised since start, ordered by oid*/
+func (self *AlarmFile) States() []AlarmState {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	var states []AlarmState
+	for _, state := range self.raised {
+		states = append(states, *state)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Oid < states[j].Oid })
+	return states
+}
+
+/*the schedule starts with the first use, so AlarmFile{} is ready to use*/
+func (self *AlarmFile) scheduled() *FlushSchedule {
+	if self.schedule == nil {
+		self.schedule = NewFlushSchedule(g_log_cfg.AlarmInterval, Now())
+	}
+	return self.schedule
+}
+
+/*Return the end of the current interval, when Flush is due*/
+func (self *AlarmFile) NextFlush() time.Time {
+	return self.scheduled().Next()
+}
+
+/* Commit tmp alarm file to alarm interface file stamped with the end of the interval */
 func (self *AlarmFile) Flush() error {
-	now := time.Now().Unix()
-	if now-self.last_flush >= g_log_cfg.AlarmInterval && now%g_log_cfg.AlarmInterval < 3 {
-		self.last_flush = now
+	due := self.scheduled().Due(Now())
+	if len(due) == 0 {
+		return nil
+	}
+	//the alarms of the missed intervals can not be told apart, commit them with the latest boundary
+	return self.flush(due[len(due)-1])
+}
 
-		filename, err := GenerateFileName("WARNING")
-		if err != nil {
-			return errors.New(fmt.Sprintf("FlushAlarmFile falied:", err))
-		}
-		target := filepath.Join(g_log_cfg.AlarmKpiPath, filename)
+/* Commit tmp alarm file regardless of the interval alignment, e.g. on shutdown */
+func (self *AlarmFile) ForceFlush() error {
+	return self.flush(Now())
+}
 
-		tmp := filepath.Join(g_log_cfg.AlarmKpiPath, ".alarm.tmp")
-		f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
-		if err != nil {
-			return errors.New(fmt.Sprintf("FlushAlarmFile falied:", err))
-		}
-		not_empty := false
-		defer func() {
-			f.Close()
-			if not_empty {
-				Info("Write WARNING file [%s]", target)
-				os.Rename(tmp, target)
-			}
-		}()
+func (self *AlarmFile) flush(t time.Time) error {
+	target, err := freeName(g_log_cfg.AlarmKpiPath, func(rc int) (string, error) { return alarmFormatter().FileName(t, rc) })
+	if err != nil {
+		return errors.New(fmt.Sprintf("FlushAlarmFile falied: %v", err))
+	}
 
-		info, err := f.Stat()
-		if err != nil {
-			return errors.New(fmt.Sprintf("FlushAlarmFile falied:", err))
-		}
-		if info.Size() > 0 {
-			not_empty = true
+	tmp := filepath.Join(g_log_cfg.AlarmKpiPath, ".alarm.tmp")
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.New(fmt.Sprintf("FlushAlarmFile falied: %v", err))
+	}
+	not_empty := false
+	defer func() {
+		f.Close()
+		if not_empty {
+			Info("Write WARNING file [%s]", target)
+			os.Rename(tmp, target)
 		}
+	}()
+
+	info, err := f.Stat()
+	if err != nil {
+		return errors.New(fmt.Sprintf("FlushAlarmFile falied: %v", err))
+	}
+	if info.Size() > 0 {
+		not_empty = true
 	}
 	return nil
 }
@@ -181,29 +423,50 @@ func ProcLogRecCycle(w io.Writer, rec_per_cycle int) error {
 	return nil
 }
 
+/*Write the pending log records to w without waiting, at most max_rec records
+return with the number of records written*/
+func ProcLogRecNowait(w io.Writer, max_rec int) (int, error) {
+	if g_log_cfg == nil || g_transport == nil {
+		return 0, errors.New("ProcLogRecNowait failed, transport not initialized")
+	}
+	for i := 0; i < max_rec; i++ {
+		b, _, err := getLogRec(true)
+		if err != nil {
+			return i, nil
+		}
+		_, err = w.Write(b)
+		if err != nil {
+			return i, err
+		}
+	}
+	return max_rec, nil
+}
+
 /*Get a Kpi Record from MQ
 return with the bytes, length, error*/
 func getKpiRec() ([]byte, int64, error) {
-	if g_log_cfg == nil {
-		return nil, 0, errors.New("getKpiRec failed, MQ not initialized")
-	}
-	return g_mq.Receive(1024, KPI_MSG_TYPE, &sysvipc.MQRecvFlags{true, true})
+	return getRec(KPI_MSG_TYPE, true)
 }
 
 /*Get a Alarm Record from MQ
 return with the bytes, length, error*/
 func getAlarmRec() ([]byte, int64, error) {
-	if g_log_cfg == nil {
-		return nil, 0, errors.New("getAlarmRec failed, MQ not initialized")
-	}
-	return g_mq.Receive(1024, ALARM_MSG_TYPE, &sysvipc.MQRecvFlags{true, true})
+	return getRec(ALARM_MSG_TYPE, true)
 }
 
 /*Get a Log Record from MQ
 return with the bytes, length, error*/
 func getLogRec(nowait bool) ([]byte, int64, error) {
-	if g_log_cfg == nil {
-		return nil, 0, errors.New("getAlarmRec failed, MQ not initialized")
+	return getRec(LOG_MSG_TYPE, nowait)
+}
+
+func getRec(kind int64, nowait bool) ([]byte, int64, error) {
+	if g_log_cfg == nil || g_transport == nil {
+		return nil, 0, errors.New(fmt.Sprintf("getRec [%d] failed, transport not initialized", kind))
+	}
+	b, err := g_transport.Receive(kind, nowait)
+	if err != nil {
+		return nil, 0, err
 	}
-	return g_mq.Receive(1024, LOG_MSG_TYPE, &sysvipc.MQRecvFlags{nowait, true})
+	return b, int64(len(b)), nil
 }
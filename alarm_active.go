@@ -0,0 +1,236 @@
+package applog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	ALARM_STATE_FILE   = ".alarm.state"
+	ALARM_RESOURCE_KEY = "resource" //the field of the resource, the last one in the content of the alarm record
+	ALARM_STATE_FLAG   = "*"        //appended to the level column of the alarm records sent by RaiseAlarm and ClearAlarm
+	ALARM_RESOURCE_SEP = ":"        //joins the oid and the resource in the key of an active alarm
+)
+
+/*An alarm raised and not cleared yet, keyed by the oid and the resource*/
+type ActiveAlarm struct {
+	Oid         string    `json:"oid"`
+	Resource    string    `json:"resource,omitempty"`
+	App         string    `json:"app"`
+	Level       string    `json:"level"`   //level of the last raise
+	Content     string    `json:"content"` //content of the last raise
+	FirstRaised time.Time `json:"first_raised"`
+	LastRaised  time.Time `json:"last_raised"`
+	Count       int64     `json:"count"` //raised times since the first raise
+}
+
+func activeKey(oid string, resource string) string {
+	return oid + ALARM_RESOURCE_SEP + resource
+}
+
+/*WARN, ERROR and FATAL raise an alarm, CLEAN clears it, EVENT is not stateful*/
+func isRaiseLevel(level LOG_LEVEL) bool {
+	return level >= WARN && level < MAX_LEVEL
+}
+
+// Raise the alarm of the resource, e.g. RaiseAlarm(ERROR, "PEER_DOWN", "hss01", "peer %s is down", ip),
+// it stays active in log_aggregator until cleared by ClearAlarm with the same alarm name and resource.
+func RaiseAlarm(level LOG_LEVEL, alarm_name string, resource string, format string, v ...interface{}) error {
+	return g_logger.RaiseAlarm(level, alarm_name, resource, format, v...)
+}
+
+/*Clear the alarm of the resource raised by RaiseAlarm*/
+func ClearAlarm(alarm_name string, resource string, format string, v ...interface{}) error {
+	return g_logger.ClearAlarm(alarm_name, resource, format, v...)
+}
+
+func (self *Logger) RaiseAlarm(level LOG_LEVEL, alarm_name string, resource string, format string, v ...interface{}) error {
+	if !isRaiseLevel(level) {
+		return errors.New(fmt.Sprintf("RaiseAlarm failed, level [%s] shall be WARN, ERROR or FATAL", Level2Str(level)))
+	}
+	return self.writeAlarm(level, alarm_name, resource, fmt.Sprintf(format, v...))
+}
+
+func (self *Logger) ClearAlarm(alarm_name string, resource string, format string, v ...interface{}) error {
+	return self.writeAlarm(CLEAN, alarm_name, resource, fmt.Sprintf(format, v...))
+}
+
+/*write the log with the resource field, the alarm record carries it in the content and keeps the oid column of WriteLog,
+its level column is flagged, so the fields of WriteLogKV can not pass for a stateful record*/
+func (self *Logger) writeAlarm(level LOG_LEVEL, alarm_name string, resource string, msg string) error {
+	if alarm_name == NO_ALARM {
+		return errors.New("writeAlarm failed, empty alarm name")
+	}
+	if strings.ContainsAny(resource, "|\n") {
+		return errors.New(fmt.Sprintf("writeAlarm failed, invalid resource [%s] of alarm [%s]", resource, alarm_name))
+	}
+	self.mutex.Lock()
+	cfg := self.cfg
+	self.mutex.Unlock()
+	if cfg == nil {
+		return errors.New("writeAlarm failed, config not loaded")
+	}
+	_, err := cfg.GetAlarmOid(alarm_name)
+	if err != nil {
+		return errors.New(fmt.Sprintf("writeAlarm failed: %v", err))
+	}
+	//the resource field marks the record stateful for log_aggregator, even if empty
+	fields := []Field{{Key: ALARM_RESOURCE_KEY, Value: resource}}
+	self.dispatch(logEntry{
+		level:    level,
+		alarm:    alarm_name,
+		stateful: true,
+		rec: LogRecord{
+			Timestamp: Now().Format("20060102-150405.000"),
+			Level:     Level2Str(level),
+			Msg:       msg,
+			Fields:    fields,
+		},
+	})
+	return nil
+}
+
+/*Return the value of the resource field ending the content of an alarm record, e.g. "peer hss01 is down resource=hss01"*/
+func alarmResource(content string) (string, bool) {
+	sep := " " + ALARM_RESOURCE_KEY + "="
+	for i := strings.Index(content, sep); i >= 0; {
+		//the first occurrence parsed as the single last field, it may also be in the msg or a quoted value
+		fields, err := parseFields(content[i+1:])
+		if err == nil && len(fields) == 1 {
+			return fields[0].Value, true
+		}
+		next := strings.Index(content[i+1:], sep)
+		if next < 0 {
+			break
+		}
+		i += 1 + next
+	}
+	return "", false
+}
+
+/*raise or clear the active alarm of the record sent by RaiseAlarm or ClearAlarm, return true if the table is changed,
+the mutex shall be held by the caller. The records of WriteLog are not stateful, they would never be cleared*/
+func (self *AlarmFile) activate(rec AlarmRecord) bool {
+	if !rec.Stateful {
+		return false
+	}
+	level := Str2Level(rec.Level)
+	key := activeKey(rec.Oid, rec.Resource)
+	if level == CLEAN {
+		_, present := self.active[key]
+		delete(self.active, key)
+		return present
+	}
+	if !isRaiseLevel(level) {
+		return false
+	}
+	if self.active == nil {
+		self.active = make(map[string]*ActiveAlarm)
+	}
+	alarm, present := self.active[key]
+	if !present {
+		alarm = &ActiveAlarm{Oid: rec.Oid, Resource: rec.Resource, FirstRaised: rec.Timestamp}
+		self.active[key] = alarm
+	}
+	alarm.App = rec.App
+	alarm.Level = rec.Level
+	alarm.Content = rec.Content
+	alarm.LastRaised = rec.Timestamp
+	alarm.Count++
+	return true
+}
+
+/*return true if the alarm of the oid is active for any resource, the mutex shall be held by the caller*/
+func (self *AlarmFile) activeOid(oid string) bool {
+	for _, a := range self.active {
+		if a.Oid == oid {
+			return true
+		}
+	}
+	return false
+}
+
+/*Return the alarms raised and not cleared, ordered by oid and resource*/
+func (self *AlarmFile) ActiveAlarms() []ActiveAlarm {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return sortedActive(self.active)
+}
+
+func sortedActive(active map[string]*ActiveAlarm) []ActiveAlarm {
+	alarms := make([]ActiveAlarm, 0, len(active))
+	for _, alarm := range active {
+		alarms = append(alarms, *alarm)
+	}
+	sort.Slice(alarms, func(i, j int) bool {
+		if alarms[i].Oid != alarms[j].Oid {
+			return alarms[i].Oid < alarms[j].Oid
+		}
+		return alarms[i].Resource < alarms[j].Resource
+	})
+	return alarms
+}
+
+/*save the active alarms to the state file under alarm_kpi_path, the mutex shall be held by the caller*/
+func (self *AlarmFile) saveActive() error {
+	b, err := json.MarshalIndent(sortedActive(self.active), "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(g_log_cfg.AlarmKpiPath, ALARM_STATE_FILE)
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, b, 0644)
+	if err != nil {
+		return errors.New(fmt.Sprintf("AlarmFile::saveActive failed: %v", err))
+	}
+	err = os.Rename(tmp, path)
+	if err != nil {
+		return errors.New(fmt.Sprintf("AlarmFile::saveActive failed: %v", err))
+	}
+	return nil
+}
+
+/*Restore the active alarms saved by last run*/
+func (self *AlarmFile) Restore() error {
+	alarms, err := LoadActiveAlarms()
+	if err != nil {
+		return err
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.active = make(map[string]*ActiveAlarm)
+	for i := range alarms {
+		self.active[activeKey(alarms[i].Oid, alarms[i].Resource)] = &alarms[i]
+	}
+	if len(alarms) > 0 {
+		Info("AlarmFile::Restore %d active alarms", len(alarms))
+	}
+	return nil
+}
+
+/*Load the active alarms saved by log_aggregator under alarm_kpi_path, e.g. to list the outstanding alarms*/
+func LoadActiveAlarms() ([]ActiveAlarm, error) {
+	if g_log_cfg == nil {
+		return nil, errors.New("LoadActiveAlarms failed, config not loaded")
+	}
+	path := filepath.Join(g_log_cfg.AlarmKpiPath, ALARM_STATE_FILE)
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("LoadActiveAlarms failed: %v", err))
+	}
+	var alarms []ActiveAlarm
+	err = json.Unmarshal(b, &alarms)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("LoadActiveAlarms failed, corrupted state file [%s]: %v", path, err))
+	}
+	return alarms, nil
+}
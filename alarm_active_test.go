@@ -0,0 +1,99 @@
+package applog
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestActiveAlarms(t *testing.T) {
+	cfg := loadTestCfg(t, 7907, func(cfg *LogCfg) {
+		cfg.AlarmOid = map[string]string{"PEER_DOWN": "1.3.1.1.4"}
+	})
+	err := InitLog("mq", "APPLICATION001")
+	if err != nil {
+		t.Fatalf("InitLog: %v", err)
+	}
+	af := &AlarmFile{}
+	RaiseAlarm(ERROR, "PEER_DOWN", "hss01", "peer %s is down", "hss01")
+	RaiseAlarm(ERROR, "PEER_DOWN", "hss02", "peer %s is down", "hss02")
+	RaiseAlarm(FATAL, "PEER_DOWN", "hss01", "peer %s is still down", "hss01")
+	ClearAlarm("PEER_DOWN", "hss02", "peer %s is up", "hss02")
+	RaiseAlarm(ERROR, "PEER_DOWN", "", "all peers are down")
+	ClearAlarm("PEER_DOWN", "", "a peer is up")
+	WriteLog(ERROR, "PEER_DOWN", "not stateful")
+	//a resource field of WriteLogKV or in the msg does not make the record stateful
+	WriteLogKV(ERROR, "PEER_DOWN", "peer down", "resource", "hss03")
+	WriteLog(ERROR, "PEER_DOWN", "peer down resource=hss04")
+	g_transport.Send(ALARM_MSG_TYPE, []byte("not an alarm record"), true)
+	af.Process()
+
+	active := af.ActiveAlarms()
+	if len(active) != 1 || active[0].Oid != "1.3.1.1.4" || active[0].Resource != "hss01" || active[0].Level != "FATAL" || active[0].Count != 2 || active[0].App != "APPLICATION001" {
+		t.Fatalf("unexpected active alarms %+v", active)
+	}
+	b, _ := os.ReadFile(filepath.Join(cfg.AlarmKpiPath, ".alarm.tmp"))
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 10 || !strings.HasSuffix(lines[3], "|APPLICATION001|CLEAN|.1.3.1.1.4|peer hss02 is up resource=hss02") || !strings.HasSuffix(lines[6], "|ERROR|.1.3.1.1.4|not stateful") ||
+		!strings.HasSuffix(lines[7], "|ERROR|.1.3.1.1.4|peer down resource=hss03") || lines[9] != "not an alarm record" {
+		t.Fatalf("unexpected alarm records\n%s", string(b))
+	}
+
+	//the active alarms survive the restart of log_aggregator
+	restored := &AlarmFile{}
+	err = restored.Restore()
+	if err != nil || len(restored.ActiveAlarms()) != 1 || restored.ActiveAlarms()[0].Resource != "hss01" {
+		t.Fatalf("Restore: %+v %v", restored.ActiveAlarms(), err)
+	}
+	w := httptest.NewRecorder()
+	NewMetricsHandler(nil, restored).ServeHTTP(w, httptest.NewRequest("GET", METRICS_PATH, nil))
+	expect := `applog_alarm_active_since_seconds{oid="1.3.1.1.4",resource="hss01",level="FATAL"} `
+	if !strings.Contains(w.Body.String(), expect) {
+		t.Fatalf("active alarm not in metrics\n%s", w.Body.String())
+	}
+
+	ClearAlarm("PEER_DOWN", "hss01", "peer %s is up", "hss01")
+	restored.Process()
+	alarms, err := LoadActiveAlarms()
+	if err != nil || len(alarms) != 0 {
+		t.Fatalf("LoadActiveAlarms: %+v %v", alarms, err)
+	}
+
+	if RaiseAlarm(INFO, "PEER_DOWN", "hss01", "not an alarm") == nil {
+		t.Fatalf("RaiseAlarm accepted INFO level")
+	}
+	if RaiseAlarm(ERROR, "PEER_DOWN", "hss|01", "invalid resource") == nil {
+		t.Fatalf("RaiseAlarm accepted resource with |")
+	}
+}
+
+func TestActiveAlarmState(t *testing.T) {
+	loadTestCfg(t, 7913, func(cfg *LogCfg) {
+		cfg.AlarmOid = map[string]string{"PEER_DOWN": "1.3.1.1.4"}
+	})
+	err := InitLog("mq", "APPLICATION001")
+	if err != nil {
+		t.Fatalf("InitLog: %v", err)
+	}
+	af := &AlarmFile{}
+	state := func() string {
+		w := httptest.NewRecorder()
+		NewMetricsHandler(nil, af).ServeHTTP(w, httptest.NewRequest("GET", METRICS_PATH, nil))
+		return w.Body.String()
+	}
+	RaiseAlarm(ERROR, "PEER_DOWN", "r1", "peer r1 is down")
+	RaiseAlarm(ERROR, "PEER_DOWN", "r2", "peer r2 is down")
+	ClearAlarm("PEER_DOWN", "r1", "peer r1 is up")
+	af.Process()
+	//r2 is still raised
+	if expect := METRIC_ALARM_STATE + `{oid="1.3.1.1.4"} 1` + "\n"; !strings.Contains(state(), expect) {
+		t.Fatalf("expect [%s] in\n%s", expect, state())
+	}
+	ClearAlarm("PEER_DOWN", "r2", "peer r2 is up")
+	af.Process()
+	if expect := METRIC_ALARM_STATE + `{oid="1.3.1.1.4"} 0` + "\n"; !strings.Contains(state(), expect) {
+		t.Fatalf("expect [%s] in\n%s", expect, state())
+	}
+}
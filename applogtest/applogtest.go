@@ -0,0 +1,178 @@
+// Package applogtest initialises applog with the in-process "memory" transport
+// and temporary directories, so unit tests can emit logs/KPIs/alarms and assert
+// on the records the log_aggregator would have received, without touching the OS.
+//
+// Setup replaces the package globals of applog (config, default Logger, transport
+// and clock) until the test ends, so the tests using it shall not call t.Parallel.
+package applogtest
+
+import (
+	"applog"
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+/*unique mq id per Harness, so that a Harness never sees the records left by the previous ones*/
+var g_mq_id = int64(1000000)
+
+type Options struct {
+	AppName  string            //application label, "APPLOGTEST" by default
+	LogFile  string            //filename passed to InitLog, "mq" by default
+	AlarmOid map[string]string //alarm name to oid mapping
+	KpiOid   map[string]string //kpi name to oid mapping
+	Debug    bool              //enable DEBUG level log
+	Clock    applog.Clock      //clock installed with applog.SetClock until the test ends, the wall clock by default
+}
+
+/*Clock standing still until advanced by the test*/
+type FakeClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (self *FakeClock) Now() time.Time {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.now
+}
+
+/*Move the clock forward by d*/
+func (self *FakeClock) Advance(d time.Duration) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.now = self.now.Add(d)
+}
+
+/*Set the clock to now*/
+func (self *FakeClock) Set(now time.Time) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.now = now
+}
+
+type Harness struct {
+	Dir       string //temporary dir used as log_path and alarm_kpi_path
+	CfgFile   string
+	t         testing.TB
+	transport *applog.MemTransport
+}
+
+/*Load a temporary config with memory transport and InitLog with the options*/
+func Setup(t testing.TB, opts Options) *Harness {
+	t.Helper()
+	if len(opts.AppName) < 1 {
+		opts.AppName = "APPLOGTEST"
+	}
+	if len(opts.LogFile) < 1 {
+		opts.LogFile = "mq"
+	}
+	if opts.Clock != nil {
+		applog.SetClock(opts.Clock)
+		t.Cleanup(func() { applog.SetClock(nil) })
+	}
+	t.Cleanup(applog.ResetLog)
+	dir := t.TempDir()
+	cfg := &applog.LogCfg{
+		MQID:         atomic.AddInt64(&g_mq_id, 1),
+		Transport:    applog.TRANSPORT_MEMORY,
+		LogPath:      dir,
+		AlarmKpiPath: dir,
+		AlarmOid:     opts.AlarmOid,
+		KpiOid:       opts.KpiOid,
+	}
+	cfg_file := filepath.Join(dir, "applogtest.cfg")
+	err := cfg.Save(cfg_file)
+	if err != nil {
+		t.Fatalf("applogtest: save config: %v", err)
+	}
+	err = applog.LoadLogCfg(cfg_file)
+	if err != nil {
+		t.Fatalf("applogtest: LoadLogCfg: %v", err)
+	}
+	err = applog.InitLog(opts.LogFile, opts.AppName)
+	if err != nil {
+		t.Fatalf("applogtest: InitLog: %v", err)
+	}
+	applog.DebugLog(opts.Debug)
+	h := &Harness{
+		Dir:       dir,
+		CfgFile:   cfg_file,
+		t:         t,
+		transport: applog.NewMemTransport(cfg.MQID),
+	}
+	t.Cleanup(func() { h.transport.Close() })
+	return h
+}
+
+/*Remove and return the pending records of the kind*/
+func (self *Harness) Records(kind int64) []string {
+	var recs []string
+	for _, b := range self.transport.Drain(kind) {
+		recs = append(recs, string(b))
+	}
+	return recs
+}
+
+/*Remove and return the pending log lines, as ProcLogRecCycle would write them*/
+func (self *Harness) Logs() []string {
+	var lines []string
+	for _, rec := range self.Records(applog.LOG_MSG_TYPE) {
+		lines = append(lines, strings.TrimRight(rec, "\n"))
+	}
+	return lines
+}
+
+/*Remove and return the pending kpi records in "oid|delta" format*/
+func (self *Harness) Kpis() []string {
+	return self.Records(applog.KPI_MSG_TYPE)
+}
+
+/*Remove and return the pending alarm records*/
+func (self *Harness) Alarms() []string {
+	return self.Records(applog.ALARM_MSG_TYPE)
+}
+
+/*Feed the pending kpi records to a new KpiFile and return its counters*/
+func (self *Harness) KpiCounters() map[string]int64 {
+	self.t.Helper()
+	kf, err := applog.NewKpiFile()
+	if err != nil {
+		self.t.Fatalf("applogtest: NewKpiFile: %v", err)
+	}
+	err = kf.Process()
+	if err != nil {
+		self.t.Fatalf("applogtest: KpiFile::Process: %v", err)
+	}
+	return kf.Counters()
+}
+
+/*Feed the pending alarm records to an AlarmFile and return the lines of its tmp file*/
+func (self *Harness) AlarmFileLines() []string {
+	self.t.Helper()
+	af := &applog.AlarmFile{}
+	err := af.Process()
+	if err != nil {
+		self.t.Fatalf("applogtest: AlarmFile::Process: %v", err)
+	}
+	f, err := os.Open(filepath.Join(self.Dir, ".alarm.tmp"))
+	if err != nil {
+		self.t.Fatalf("applogtest: open alarm tmp file: %v", err)
+	}
+	defer f.Close()
+	var lines []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		lines = append(lines, s.Text())
+	}
+	return lines
+}
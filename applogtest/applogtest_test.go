@@ -0,0 +1,104 @@
+package applogtest
+
+import (
+	"applog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHarness(t *testing.T) {
+	h := Setup(t, Options{
+		AppName:  "APPLICATION003",
+		AlarmOid: map[string]string{"DB_FAIL": "1.3.1.1.1"},
+		KpiOid:   map[string]string{"REQ_COUNT": "1.3.1.2.1", "RES_COUNT": "1.3.1.2.3"},
+	})
+
+	applog.Db("suppressed debug log")
+	applog.Info("info log [%d]", 10238)
+	applog.WriteLog(applog.ERROR, "DB_FAIL", "Failed to connect to db [%s:%d]", "localhost", 3868)
+	logs := h.Logs()
+	if len(logs) != 2 {
+		t.Fatalf("expect 2 log lines but %d: %v", len(logs), logs)
+	}
+	if !strings.HasSuffix(logs[0], "|APPLICATION003|INFO|info log [10238]") {
+		t.Fatalf("unexpected log line [%s]", logs[0])
+	}
+
+	applog.IncreaseKpi("REQ_COUNT")
+	applog.WriteKpi("REQ_COUNT", 100)
+	applog.WriteKpi("RES_COUNT", 3)
+	counters := h.KpiCounters()
+	if counters["1.3.1.2.1"] != 101 || counters["1.3.1.2.3"] != 3 {
+		t.Fatalf("unexpected kpi counters %v", counters)
+	}
+
+	lines := h.AlarmFileLines()
+	if len(lines) != 1 || !strings.HasSuffix(lines[0], "|APPLICATION003|ERROR|.1.3.1.1.1|Failed to connect to db [localhost:3868]") {
+		t.Fatalf("unexpected alarm lines %v", lines)
+	}
+}
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2016, 5, 14, 4, 10, 0, 0, time.Local) //aligned to the 300s kpi interval
+	clock := NewFakeClock(start.Add(30 * time.Second))
+	h := Setup(t, Options{
+		AlarmOid: map[string]string{"DB_FAIL": "1.3.1.1.1"},
+		KpiOid:   map[string]string{"REQ_COUNT": "1.3.1.2.1"},
+		Clock:    clock,
+	})
+	exists := func(pattern string, at time.Time) bool {
+		filename, _ := applog.GenerateFileNameAt(pattern, at)
+		_, err := os.Stat(filepath.Join(h.Dir, filename))
+		return err == nil
+	}
+
+	kf, err := applog.NewKpiFile()
+	if err != nil {
+		t.Fatalf("NewKpiFile: %v", err)
+	}
+	applog.WriteKpi("REQ_COUNT", 3)
+	kf.Process()
+	clock.Advance(269 * time.Second)
+	kf.Flush()
+	if exists("KPI", start.Add(300*time.Second)) {
+		t.Fatalf("KPI file written before the boundary")
+	}
+	clock.Advance(1 * time.Second)
+	kf.Flush()
+	if !exists("KPI", start.Add(300*time.Second)) {
+		t.Fatalf("KPI file not written at the boundary")
+	}
+	clock.Advance(650 * time.Second)
+	kf.Flush()
+	if !exists("KPI", start.Add(600*time.Second)) || !exists("KPI", start.Add(900*time.Second)) {
+		t.Fatalf("KPI files of the missed intervals not written")
+	}
+
+	af := &applog.AlarmFile{}
+	af.Flush()
+	applog.WriteLog(applog.ERROR, "DB_FAIL", "Failed to connect to db")
+	af.Process()
+	boundary := af.NextFlush()
+	clock.Set(boundary)
+	af.Flush()
+	if !exists("WARNING", boundary) {
+		t.Fatalf("WARNING file not written at the boundary [%v]", boundary)
+	}
+
+	path := filepath.Join(h.Dir, "app.log")
+	rf, err := applog.NewRotatingFile(path, applog.RotatePolicy{Period: applog.ROTATE_DAY})
+	if err != nil {
+		t.Fatalf("NewRotatingFile: %v", err)
+	}
+	defer rf.Close()
+	rf.Write([]byte("day 1\n"))
+	clock.Set(start.Add(24 * time.Hour))
+	rf.Write([]byte("day 2\n"))
+	b, err := os.ReadFile(path + "." + start.Format("20060102"))
+	if err != nil || string(b) != "day 1\n" {
+		t.Fatalf("app.log not rotated at the day switch: [%s] %v", string(b), err)
+	}
+}
@@ -0,0 +1,209 @@
+package applog
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+type OverflowPolicy int
+
+const (
+	OVERFLOW_BLOCK       = OverflowPolicy(0) //wait for the space in the queue
+	OVERFLOW_DROP_NEWEST = OverflowPolicy(1) //drop the record being written
+	OVERFLOW_DROP_OLDEST = OverflowPolicy(2) //drop the oldest record in the queue
+
+	DROPPED_KPI_NAME = "APPLOG_DROPPED" //internal kpi of dropped log records, reported if configured in KpiOid
+	ASYNC_BATCH_SIZE = 256
+)
+
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	switch s {
+	case "", "block":
+		return OVERFLOW_BLOCK, nil
+	case "drop_newest":
+		return OVERFLOW_DROP_NEWEST, nil
+	case "drop_oldest":
+		return OVERFLOW_DROP_OLDEST, nil
+	default:
+		return OVERFLOW_BLOCK, errors.New(fmt.Sprintf("ParseOverflowPolicy: invalid policy [%s]", s))
+	}
+}
+
+type logEntry struct {
+	level    LOG_LEVEL
+	alarm    string
+	stateful bool //sent by RaiseAlarm or ClearAlarm
+	rec      LogRecord
+}
+
+/*bounded ring buffer of log entries*/
+type asyncQueue struct {
+	buf       []logEntry
+	head      int
+	count     int
+	policy    OverflowPolicy
+	dropped   uint64
+	closed    bool
+	done      chan struct{} //closed when the drain routine exits
+	mutex     sync.Mutex
+	not_empty *sync.Cond
+	not_full  *sync.Cond
+}
+
+func newAsyncQueue(size int, policy OverflowPolicy) *asyncQueue {
+	q := &asyncQueue{
+		buf:    make([]logEntry, size),
+		policy: policy,
+		done:   make(chan struct{}),
+	}
+	q.not_empty = sync.NewCond(&q.mutex)
+	q.not_full = sync.NewCond(&q.mutex)
+	return q
+}
+
+/*push the entry, return false if the queue is closed*/
+func (self *asyncQueue) push(e logEntry) bool {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	for !self.closed && self.count == len(self.buf) {
+		switch self.policy {
+		case OVERFLOW_DROP_NEWEST:
+			self.dropped++
+			return true
+		case OVERFLOW_DROP_OLDEST:
+			self.head = (self.head + 1) % len(self.buf)
+			self.count--
+			self.dropped++
+		default:
+			self.not_full.Wait()
+		}
+	}
+	if self.closed {
+		return false
+	}
+	self.buf[(self.head+self.count)%len(self.buf)] = e
+	self.count++
+	self.not_empty.Signal()
+	return true
+}
+
+/*wait and pop at most max entries, return nil if the queue is closed and empty*/
+func (self *asyncQueue) pop(max int) []logEntry {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	for !self.closed && self.count == 0 {
+		self.not_empty.Wait()
+	}
+	if self.count == 0 {
+		return nil
+	}
+	n := self.count
+	if n > max {
+		n = max
+	}
+	entries := make([]logEntry, n)
+	for i := 0; i < n; i++ {
+		entries[i] = self.buf[self.head]
+		self.buf[self.head] = logEntry{}
+		self.head = (self.head + 1) % len(self.buf)
+	}
+	self.count -= n
+	self.not_full.Broadcast()
+	return entries
+}
+
+func (self *asyncQueue) droppedCount() uint64 {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.dropped
+}
+
+func (self *asyncQueue) close() {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.closed = true
+	self.not_empty.Broadcast()
+	self.not_full.Broadcast()
+}
+
+/*write log records asynchronously via a bounded queue of the size, drained by a background routine*/
+func WithAsync(size int, policy OverflowPolicy) LoggerOption {
+	return func(l *Logger) {
+		l.async_size = size
+		l.async_policy = policy
+	}
+}
+
+/*start the async queue if configured via WithAsync or the async_queue_size item of the config*/
+func (self *Logger) startAsync() error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.queue.Load() != nil {
+		return nil
+	}
+	size := self.async_size
+	policy := self.async_policy
+	if size <= 0 && self.cfg != nil && self.cfg.AsyncQueueSize > 0 {
+		size = self.cfg.AsyncQueueSize
+		p, err := ParseOverflowPolicy(self.cfg.AsyncOverflow)
+		if err != nil {
+			return err
+		}
+		policy = p
+	}
+	if size <= 0 {
+		return nil
+	}
+	q := newAsyncQueue(size, policy)
+	self.queue.Store(q)
+	go self.drainRoutine(q)
+	return nil
+}
+
+/*close the async queue and wait for the pending records written*/
+func (self *Logger) stopAsync() {
+	q := self.queue.Swap(nil)
+	if q == nil {
+		return
+	}
+	q.close()
+	<-q.done
+	self.dropped_base.Add(q.droppedCount())
+}
+
+func (self *Logger) drainRoutine(q *asyncQueue) {
+	defer close(q.done)
+	reported := uint64(0)
+	for {
+		entries := q.pop(ASYNC_BATCH_SIZE)
+		if entries == nil {
+			return
+		}
+		self.mutex.Lock()
+		for i := range entries {
+			self.emit(&entries[i])
+		}
+		dropped := q.droppedCount()
+		if dropped > reported {
+			self.sendKpi(DROPPED_KPI_NAME, int64(dropped-reported))
+			reported = dropped
+		}
+		self.mutex.Unlock()
+	}
+}
+
+/*Number of log records dropped by the async queue of the Logger*/
+func (self *Logger) DroppedRecords() uint64 {
+	dropped := self.dropped_base.Load()
+	q := self.queue.Load()
+	if q != nil {
+		dropped += q.droppedCount()
+	}
+	return dropped
+}
+
+/*Number of log records dropped by the async queue of the default Logger*/
+func DroppedLogRecords() uint64 {
+	return g_logger.DroppedRecords()
+}
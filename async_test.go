@@ -0,0 +1,62 @@
+package applog
+
+import (
+	"testing"
+)
+
+/*transport blocking the log records until released*/
+type blockingTransport struct {
+	MemTransport
+	sending chan struct{}
+	release chan struct{}
+}
+
+func (self *blockingTransport) Send(kind int64, rec []byte, nowait bool) error {
+	if kind == LOG_MSG_TYPE {
+		self.sending <- struct{}{}
+		<-self.release
+	}
+	return self.MemTransport.Send(kind, rec, nowait)
+}
+
+func TestAsyncDropNewest(t *testing.T) {
+	cfg := &LogCfg{
+		MQID:    7894,
+		LogPath: t.TempDir(),
+		KpiOid:  map[string]string{DROPPED_KPI_NAME: "1.3.1.2.99"},
+	}
+	bt := &blockingTransport{
+		MemTransport: *NewMemTransport(cfg.MQID),
+		sending:      make(chan struct{}),
+		release:      make(chan struct{}),
+	}
+	l, err := NewLogger(cfg, "ASYNC001", WithLogFile("mq"), WithTransport(bt), WithAsync(2, OVERFLOW_DROP_NEWEST))
+	if err != nil {
+		t.Fatalf("NewLogger: %v", err)
+	}
+	l.Info("record %d", 0)
+	<-bt.sending //the drain routine is blocked on record 0
+	for i := 1; i <= 5; i++ {
+		l.Info("record %d", i) //record 1,2 are queued, 3,4,5 are dropped
+	}
+	if l.DroppedRecords() != 3 {
+		t.Fatalf("expect 3 dropped records but %d", l.DroppedRecords())
+	}
+	go func() {
+		for range bt.sending {
+			bt.release <- struct{}{}
+		}
+	}()
+	bt.release <- struct{}{}
+	l.Close()
+	close(bt.sending)
+
+	logs := bt.Drain(LOG_MSG_TYPE)
+	if len(logs) != 3 {
+		t.Fatalf("expect 3 log records but %q", logs)
+	}
+	kpis := bt.Drain(KPI_MSG_TYPE)
+	if len(kpis) != 1 || string(kpis[0]) != "1.3.1.2.99|3" {
+		t.Fatalf("unexpected dropped kpi records %q", kpis)
+	}
+}
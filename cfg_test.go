@@ -2,6 +2,7 @@ package applog
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -40,3 +41,19 @@ func TestGenAlarmFilename(t *testing.T) {
 	fmt.Println(GenerateFileName("WARNING"))
 	fmt.Println(GenerateFileName("KPI"))
 }
+
+func TestDumpCfg(t *testing.T) {
+	cfg := &LogCfg{
+		KpiFormat:  FORMAT_CSV,
+		LogRotate:  RotatePolicy{Period: ROTATE_DAY},
+		LogRoutes:  []LogRoute{{MinLevel: "ERROR", File: "errors.log"}},
+		KpiType:    map[string]string{"LATENCY": KPI_HISTOGRAM},
+		KpiBuckets: map[string][]int64{"LATENCY": {10, 100}},
+	}
+	dump := cfg.Dump()
+	for _, expect := range []string{"kpi_format[csv]", "log_rotate[{MaxSize:0 Period:day", "File:errors.log", "kpi_type[map[LATENCY:histogram]]", "kpi_buckets[map[LATENCY:[10 100]]]"} {
+		if !strings.Contains(dump, expect) {
+			t.Fatalf("expect [%s] in\n%s", expect, dump)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package applog
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+/*Clock is the source of the time used by the log timestamps, the file names, the rotation and the KPI/alarm intervals*/
+type Clock interface {
+	Now() time.Time
+}
+
+/*the wall clock*/
+type systemClock struct{}
+
+func (self systemClock) Now() time.Time {
+	return time.Now()
+}
+
+var g_clock atomic.Pointer[Clock]
+
+func init() {
+	SetClock(nil)
+}
+
+/*Replace the clock, e.g. with a fake clock in tests, nil restores the wall clock*/
+func SetClock(c Clock) {
+	if c == nil {
+		c = systemClock{}
+	}
+	g_clock.Store(&c)
+}
+
+/*Return the current time of the clock*/
+func Now() time.Time {
+	return (*g_clock.Load()).Now()
+}
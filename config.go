@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"sync"
 	"time"
 )
@@ -15,29 +16,53 @@ const (
 	LOG_MSG_TYPE   = int64(12)
 	DEFAULT_MQID   = int64(7888)
 	NO_ALARM       = ""
+
+	DEFAULT_SOCKET_NAME = "applog.sock"
 )
 
 type LogCfg struct {
-	MQID          int64             `json:"mq_id"`
-	LogPath       string            `json:"log_path"`
-	AlarmKpiPath  string            `json:"alarm_kpi_path"`
-	KpiInterval   int64             `json:"kpi_interval"`
-	AlarmInterval int64             `json:"alarm_interval"`
-	AlarmOid      map[string]string `jason:"alarm_oid"`
-	KpiOid        map[string]string `jason:"kpi_oid"`
-	mutex         sync.Mutex
+	MQID           int64                 `json:"mq_id"`
+	Transport      string                `json:"transport"`
+	SocketPath     string                `json:"socket_path"`
+	LogPath        string                `json:"log_path"`
+	AlarmKpiPath   string                `json:"alarm_kpi_path"`
+	KpiInterval    int64                 `json:"kpi_interval"`
+	KpiBatchWindow int64                 `json:"kpi_batch_ms"` //milliseconds to accumulate the kpi deltas in the application, 0 to send a record per call
+	KpiFormat      string                `json:"kpi_format"`   //format of the KPI files, pipe (default), csv, json or 3gpp
+	AlarmInterval  int64                 `json:"alarm_interval"`
+	AlarmFormat    string                `json:"alarm_format"` //format of the WARNING files, pipe (default), csv or json
+	AsyncQueueSize int                   `json:"async_queue_size"`
+	AsyncOverflow  string                `json:"async_overflow"`
+	LogRotate      RotatePolicy          `json:"log_rotate"`
+	AppLogKeep     HousekeepPolicy       `json:"app_log_housekeep"`
+	LogRoutes      []LogRoute            `json:"log_routes"`
+	AlarmOid       map[string]string     `jason:"alarm_oid"`
+	KpiOid         map[string]string     `jason:"kpi_oid"`
+	KpiType        map[string]string     `json:"kpi_type"`    //kpi name to kind: counter (default), updown, gauge or histogram
+	KpiBuckets     map[string][]int64    `json:"kpi_buckets"` //histogram kpi name to the ascending bucket boundaries
+	KpiLabels      map[string][]KpiLabel `json:"kpi_labels"`  //kpi name to its labels in the oid suffix order
+	kpi_kinds      map[string]string     //kpi oid to kind
+	kpi_buckets    map[string][]int64    //histogram kpi oid to bucket boundaries
+	mutex          sync.Mutex
 }
 
 func (self *LogCfg) Dump() string {
-	return fmt.Sprintf("mq_id[%d]\nlog_path[%s]\nalarm_kpi_path[%s]\nkpi_interval[%d]\nalarm_interval[%d]\nalarm_oid[%v]\nkpi_oid[%v]\n", self.MQID, self.LogPath, self.AlarmKpiPath, self.KpiInterval, self.AlarmInterval, self.AlarmOid, self.KpiOid)
+	return fmt.Sprintf("mq_id[%d]\ntransport[%s]\nsocket_path[%s]\nlog_path[%s]\nalarm_kpi_path[%s]\nkpi_interval[%d]\nalarm_interval[%d]\nalarm_oid[%v]\nkpi_oid[%v]\n", self.MQID, self.Transport, self.SocketPath, self.LogPath, self.AlarmKpiPath, self.KpiInterval, self.AlarmInterval, self.AlarmOid, self.KpiOid) +
+		fmt.Sprintf("kpi_batch_ms[%d]\nkpi_format[%s]\nalarm_format[%s]\nasync_queue_size[%d]\nasync_overflow[%s]\nlog_rotate[%+v]\napp_log_housekeep[%+v]\nlog_routes[%+v]\n", self.KpiBatchWindow, self.KpiFormat, self.AlarmFormat, self.AsyncQueueSize, self.AsyncOverflow, self.LogRotate, self.AppLogKeep, self.LogRoutes) +
+		fmt.Sprintf("kpi_type[%v]\nkpi_buckets[%v]\nkpi_labels[%+v]\n", self.KpiType, self.KpiBuckets, self.KpiLabels)
 }
 
 func GenerateFileName(pattern string) (string, error) {
+	return GenerateFileNameAt(pattern, Now())
+}
+
+/*Generate the filename stamped with the time t instead of now*/
+func GenerateFileNameAt(pattern string, t time.Time) (string, error) {
 	hostname, err := os.Hostname()
 	if err != nil {
 		return "", errors.New(fmt.Sprintf("GenerateFileName failed: %v", err))
 	}
-	return fmt.Sprintf("%s-%s-%s.txt", hostname, pattern, time.Now().Format("20060102150405")), nil
+	return fmt.Sprintf("%s-%s-%s.txt", hostname, pattern, t.Format("20060102150405")), nil
 }
 
 func ValidateFile(path string) error {
@@ -109,13 +134,52 @@ func (self *LogCfg) Load(file string) error {
 	if err != nil {
 		return err
 	}
+	_, err = ParseOverflowPolicy(self.AsyncOverflow)
+	if err != nil {
+		return err
+	}
+	err = self.LogRotate.Validate()
+	if err != nil {
+		return err
+	}
+	for i := range self.LogRoutes {
+		err = self.LogRoutes[i].Validate()
+		if err != nil {
+			return err
+		}
+	}
+	_, err = GetKpiFormatter(self.KpiFormat)
+	if err != nil {
+		return err
+	}
+	_, err = GetAlarmFormatter(self.AlarmFormat)
+	if err != nil {
+		return err
+	}
+	err = self.loadKpiKinds()
+	if err != nil {
+		return err
+	}
+	err = self.loadKpiLabels()
+	if err != nil {
+		return err
+	}
+	if self.AppLogKeep.RetentionDays < 0 || self.AppLogKeep.MaxTotalSize < 0 {
+		return errors.New("app_log_housekeep: retention_days and max_total_size shall not be negative")
+	}
 	if self.MQID <= 0 {
 		self.MQID = DEFAULT_MQID
 	}
+	if len(self.Transport) < 1 {
+		self.Transport = DEFAULT_TRANSPORT
+	}
+	if len(self.SocketPath) < 1 {
+		self.SocketPath = filepath.Join(self.LogPath, DEFAULT_SOCKET_NAME)
+	}
 	if self.KpiInterval <= 60 {
 		self.KpiInterval = 5 * 60 //write kpi stat file per 5 minutes by default
 	}
-	if self.AlarmInterval <= 1 && self.AlarmInterval >= 60 {
+	if self.AlarmInterval < 1 {
 		self.AlarmInterval = 5 //write self file per 5 second by default
 	}
 	return nil
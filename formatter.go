@@ -0,0 +1,369 @@
+package applog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	FORMAT_PIPE = "pipe" //the legacy pipe delimited rows in .txt files
+	FORMAT_CSV  = "csv"  //comma separated rows with a header line in .csv files
+	FORMAT_JSON = "json" //JSON Lines in .jsonl files
+
+	KPI_CSV_HEADER   = "timestamp,collector,type,oid,value"
+	ALARM_CSV_HEADER = "timestamp,app,level,oid,resource,content"
+
+	MAX_FILE_RC = 999 //the running count appended to a taken KPI/WARNING file name
+)
+
+// KpiFormatter renders the counters of an interval as the content of a KPI file,
+// selected by the kpi_format item of the config.
+type KpiFormatter interface {
+	// the file name of the interval [begin, end), rc > 0 if the names of the smaller rc are taken
+	FileName(begin time.Time, end time.Time, rc int) (string, error)
+	Format(counters map[string]int64, begin time.Time, end time.Time) ([]byte, error)
+	// check if the content of a tmp file left by a crash is complete
+	Valid(content []byte) bool
+}
+
+// AlarmFormatter renders the alarm records as the rows of a WARNING file,
+// selected by the alarm_format item of the config.
+type AlarmFormatter interface {
+	// the file name of the WARNING file committed at t, rc > 0 if the names of the smaller rc are taken
+	FileName(t time.Time, rc int) (string, error)
+	// the first line of the file, nil if none
+	Header() []byte
+	Row(rec AlarmRecord) []byte
+	// check if the content of a tmp file left by a crash is complete
+	Valid(content []byte) bool
+}
+
+// KpiPeriodReader is implemented by the KPI formatters telling the interval from the content of a file,
+// a tmp file left by a crash is then named by its interval instead of its modification time.
+type KpiPeriodReader interface {
+	Period(content []byte) (time.Time, time.Time, error)
+}
+
+// an alarm record sent by the application, "20160514041503|APP|ERROR|.1.3.1.1.1|content" on the wire,
+// "20160514041503|APP|CLEAN*|.1.3.1.1.1|content resource=hss01" if raised or cleared by RaiseAlarm or ClearAlarm
+type AlarmRecord struct {
+	Timestamp time.Time
+	App       string
+	Level     string
+	Oid       string
+	Resource  string
+	Content   string
+	Stateful  bool //sent by RaiseAlarm or ClearAlarm, i.e. the level is flagged, with the resource field even if empty
+}
+
+/*Parse the alarm record sent by the application*/
+func ParseAlarmRecord(line string) (AlarmRecord, error) {
+	sv := strings.SplitN(line, "|", 5)
+	if len(sv) != 5 {
+		return AlarmRecord{}, errors.New(fmt.Sprintf("ParseAlarmRecord: invalid alarm record [%s]", line))
+	}
+	ts, err := time.ParseInLocation("20060102150405", sv[0], time.Local)
+	if err != nil {
+		return AlarmRecord{}, errors.New(fmt.Sprintf("ParseAlarmRecord: invalid timestamp of alarm record [%s]", line))
+	}
+	rec := AlarmRecord{Timestamp: ts, App: sv[1], Level: sv[2], Oid: strings.TrimPrefix(sv[3], "."), Content: sv[4]}
+	if strings.HasSuffix(rec.Level, ALARM_STATE_FLAG) {
+		rec.Level = strings.TrimSuffix(rec.Level, ALARM_STATE_FLAG)
+		rec.Resource, rec.Stateful = alarmResource(rec.Content)
+	}
+	return rec, nil
+}
+
+var g_formatter_mutex sync.Mutex
+var g_kpi_formatters = map[string]KpiFormatter{
+	"":              &pipeKpiFormatter{},
+	FORMAT_PIPE:     &pipeKpiFormatter{},
+	FORMAT_CSV:      &csvKpiFormatter{},
+	FORMAT_JSON:     &jsonKpiFormatter{},
+	KPI_FORMAT_3GPP: &measKpiFormatter{},
+}
+var g_alarm_formatters = map[string]AlarmFormatter{
+	"":          &pipeAlarmFormatter{},
+	FORMAT_PIPE: &pipeAlarmFormatter{},
+	FORMAT_CSV:  &csvAlarmFormatter{},
+	FORMAT_JSON: &jsonAlarmFormatter{},
+}
+
+/*Register a KPI formatter to be selected by name in kpi_format, shall be called before LoadLogCfg*/
+func RegisterKpiFormatter(name string, f KpiFormatter) {
+	g_formatter_mutex.Lock()
+	defer g_formatter_mutex.Unlock()
+	g_kpi_formatters[name] = f
+}
+
+/*Register an alarm formatter to be selected by name in alarm_format, shall be called before LoadLogCfg*/
+func RegisterAlarmFormatter(name string, f AlarmFormatter) {
+	g_formatter_mutex.Lock()
+	defer g_formatter_mutex.Unlock()
+	g_alarm_formatters[name] = f
+}
+
+func GetKpiFormatter(name string) (KpiFormatter, error) {
+	g_formatter_mutex.Lock()
+	defer g_formatter_mutex.Unlock()
+	f, present := g_kpi_formatters[name]
+	if !present {
+		return nil, errors.New(fmt.Sprintf("kpi_format: unknown format [%s]", name))
+	}
+	return f, nil
+}
+
+func GetAlarmFormatter(name string) (AlarmFormatter, error) {
+	g_formatter_mutex.Lock()
+	defer g_formatter_mutex.Unlock()
+	f, present := g_alarm_formatters[name]
+	if !present {
+		return nil, errors.New(fmt.Sprintf("alarm_format: unknown format [%s]", name))
+	}
+	return f, nil
+}
+
+/*the file name [hostname]-[pattern]-[ts].[ext], with the running count "_rc" appended to [ts] if rc > 0*/
+func stampedFileName(pattern string, t time.Time, rc int, ext string) (string, error) {
+	name, err := GenerateFileNameAt(pattern, t)
+	if err != nil {
+		return "", err
+	}
+	name = strings.TrimSuffix(name, ".txt")
+	if rc > 0 {
+		name = fmt.Sprintf("%s_%d", name, rc)
+	}
+	return name + ext, nil
+}
+
+/*the oids of the counters in order*/
+func sortedOids(counters map[string]int64) []string {
+	oids := make([]string, 0, len(counters))
+	for oid, _ := range counters {
+		oids = append(oids, oid)
+	}
+	sort.Strings(oids)
+	return oids
+}
+
+/*check if the content ends with a line break and each line is valid*/
+func validRows(content []byte, valid func(line string) bool) bool {
+	if len(content) > 0 && !bytes.HasSuffix(content, []byte("\n")) {
+		return false //the last line is partially written
+	}
+	s := bufio.NewScanner(bytes.NewReader(content))
+	for s.Scan() {
+		if !valid(s.Text()) {
+			return false
+		}
+	}
+	return s.Err() == nil
+}
+
+/*a csv line of the fields*/
+func csvLine(fields ...string) []byte {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(fields)
+	w.Flush()
+	return buf.Bytes()
+}
+
+/*check the csv content has the header and the lines of n fields*/
+func validCsv(content []byte, header string, n int) bool {
+	if !bytes.HasPrefix(content, []byte(header+"\n")) || !bytes.HasSuffix(content, []byte("\n")) {
+		return false
+	}
+	r := csv.NewReader(bytes.NewReader(content))
+	r.FieldsPerRecord = n
+	_, err := r.ReadAll()
+	return err == nil
+}
+
+var kpi_line_re = regexp.MustCompile(`^\d{14}\|kpi_collector\|KPI\|[^|]+\|-?\d+$`)
+var alarm_line_re = regexp.MustCompile(`^\d{14}\|[^|]*\|[A-Z]+\|[^|]*\|.*$`)
+
+/*20160514041503|kpi_collector|KPI|1.3.6.1.4.1.193.176.10.2.1.0|360000*/
+type pipeKpiFormatter struct{}
+
+func (self *pipeKpiFormatter) FileName(begin time.Time, end time.Time, rc int) (string, error) {
+	return stampedFileName("KPI", end, rc, ".txt")
+}
+
+func (self *pipeKpiFormatter) Format(counters map[string]int64, begin time.Time, end time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	ts := end.Format("20060102150405")
+	for _, oid := range sortedOids(counters) {
+		fmt.Fprintf(&buf, "%s|kpi_collector|KPI|%s|%d\n", ts, oid, counters[oid])
+	}
+	return buf.Bytes(), nil
+}
+
+func (self *pipeKpiFormatter) Valid(content []byte) bool {
+	return validRows(content, kpi_line_re.MatchString)
+}
+
+/*the pipe rows with a header line*/
+type csvKpiFormatter struct{}
+
+func (self *csvKpiFormatter) FileName(begin time.Time, end time.Time, rc int) (string, error) {
+	return stampedFileName("KPI", end, rc, ".csv")
+}
+
+func (self *csvKpiFormatter) Format(counters map[string]int64, begin time.Time, end time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(KPI_CSV_HEADER + "\n")
+	ts := end.Format("20060102150405")
+	for _, oid := range sortedOids(counters) {
+		buf.Write(csvLine(ts, "kpi_collector", "KPI", oid, fmt.Sprintf("%d", counters[oid])))
+	}
+	return buf.Bytes(), nil
+}
+
+func (self *csvKpiFormatter) Valid(content []byte) bool {
+	return validCsv(content, KPI_CSV_HEADER, 5)
+}
+
+/*{"ts":"20160514041500","begin":"20160514041000","oid":"1.3.1.2.1","value":5} per line*/
+type jsonKpiFormatter struct{}
+
+type jsonKpiRow struct {
+	Timestamp string `json:"ts"`
+	Begin     string `json:"begin"`
+	Oid       string `json:"oid"`
+	Value     int64  `json:"value"`
+}
+
+func (self *jsonKpiFormatter) FileName(begin time.Time, end time.Time, rc int) (string, error) {
+	return stampedFileName("KPI", end, rc, ".jsonl")
+}
+
+func (self *jsonKpiFormatter) Format(counters map[string]int64, begin time.Time, end time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, oid := range sortedOids(counters) {
+		err := enc.Encode(&jsonKpiRow{end.Format("20060102150405"), begin.Format("20060102150405"), oid, counters[oid]})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func (self *jsonKpiFormatter) Valid(content []byte) bool {
+	return validRows(content, func(line string) bool { return json.Valid([]byte(line)) })
+}
+
+/*20160514041503|APP|ERROR|.1.3.1.1.1|content as sent by the application*/
+type pipeAlarmFormatter struct{}
+
+func (self *pipeAlarmFormatter) FileName(t time.Time, rc int) (string, error) {
+	return stampedFileName("WARNING", t, rc, ".txt")
+}
+
+func (self *pipeAlarmFormatter) Header() []byte {
+	return nil
+}
+
+func (self *pipeAlarmFormatter) Row(rec AlarmRecord) []byte {
+	return []byte(fmt.Sprintf("%s|%s|%s|.%s|%s\n", rec.Timestamp.Format("20060102150405"), rec.App, rec.Level, rec.Oid, rec.Content))
+}
+
+func (self *pipeAlarmFormatter) Valid(content []byte) bool {
+	return validRows(content, alarm_line_re.MatchString)
+}
+
+type csvAlarmFormatter struct{}
+
+func (self *csvAlarmFormatter) FileName(t time.Time, rc int) (string, error) {
+	return stampedFileName("WARNING", t, rc, ".csv")
+}
+
+func (self *csvAlarmFormatter) Header() []byte {
+	return []byte(ALARM_CSV_HEADER + "\n")
+}
+
+func (self *csvAlarmFormatter) Row(rec AlarmRecord) []byte {
+	return csvLine(rec.Timestamp.Format("20060102150405"), rec.App, rec.Level, rec.Oid, rec.Resource, rec.Content)
+}
+
+func (self *csvAlarmFormatter) Valid(content []byte) bool {
+	return validCsv(content, ALARM_CSV_HEADER, 6)
+}
+
+/*{"ts":"20160514041503","app":"APP","level":"ERROR","oid":"1.3.1.1.1","msg":"content"} per line*/
+type jsonAlarmFormatter struct{}
+
+type jsonAlarmRow struct {
+	Timestamp string `json:"ts"`
+	App       string `json:"app"`
+	Level     string `json:"level"`
+	Oid       string `json:"oid"`
+	Resource  string `json:"resource,omitempty"`
+	Msg       string `json:"msg"`
+}
+
+func (self *jsonAlarmFormatter) FileName(t time.Time, rc int) (string, error) {
+	return stampedFileName("WARNING", t, rc, ".jsonl")
+}
+
+func (self *jsonAlarmFormatter) Header() []byte {
+	return nil
+}
+
+func (self *jsonAlarmFormatter) Row(rec AlarmRecord) []byte {
+	b, _ := json.Marshal(&jsonAlarmRow{rec.Timestamp.Format("20060102150405"), rec.App, rec.Level, rec.Oid, rec.Resource, rec.Content})
+	return append(b, '\n')
+}
+
+func (self *jsonAlarmFormatter) Valid(content []byte) bool {
+	return validRows(content, func(line string) bool { return json.Valid([]byte(line)) })
+}
+
+/*the formatter of the KPI files configured, pipe if unknown*/
+func kpiFormatter() KpiFormatter {
+	f, err := GetKpiFormatter(g_log_cfg.KpiFormat)
+	if err != nil {
+		return &pipeKpiFormatter{}
+	}
+	return f
+}
+
+/*the formatter of the WARNING files configured, pipe if unknown*/
+func alarmFormatter() AlarmFormatter {
+	f, err := GetAlarmFormatter(g_log_cfg.AlarmFormat)
+	if err != nil {
+		return &pipeAlarmFormatter{}
+	}
+	return f
+}
+
+/*return the path of the first file name of rc not taken under dir, up to MAX_FILE_RC*/
+func freeName(dir string, name func(rc int) (string, error)) (string, error) {
+	for rc := 0; rc <= MAX_FILE_RC; rc++ {
+		filename, err := name(rc)
+		if err != nil {
+			return "", err
+		}
+		target := filepath.Join(dir, filename)
+		_, err = os.Stat(target)
+		if os.IsNotExist(err) {
+			return target, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return "", errors.New(fmt.Sprintf("no free file name under [%s] after %d tries", dir, MAX_FILE_RC+1))
+}
@@ -0,0 +1,152 @@
+package applog
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatters(t *testing.T) {
+	cfg := loadTestCfg(t, 7906, func(cfg *LogCfg) {
+		cfg.KpiFormat = FORMAT_CSV
+		cfg.AlarmFormat = FORMAT_JSON
+		cfg.AlarmOid = map[string]string{"DB_FAIL": "1.3.1.1.1"}
+	})
+	err := InitLog("mq", "APPLICATION001")
+	if err != nil {
+		t.Fatalf("InitLog: %v", err)
+	}
+	kf, err := NewKpiFile()
+	if err != nil {
+		t.Fatalf("NewKpiFile: %v", err)
+	}
+	WriteKpi("REQ_COUNT", 5)
+	kf.Process()
+	err = kf.ForceFlush()
+	if err != nil {
+		t.Fatalf("ForceFlush: %v", err)
+	}
+	files, _ := filepath.Glob(filepath.Join(cfg.AlarmKpiPath, "*-KPI-*.csv"))
+	if len(files) != 1 {
+		t.Fatalf("unexpected csv KPI files %v", files)
+	}
+	b, _ := os.ReadFile(files[0])
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 3 || lines[0] != KPI_CSV_HEADER || !strings.HasSuffix(lines[1], ",kpi_collector,KPI,1.3.1.2.1,5") {
+		t.Fatalf("unexpected csv KPI file\n%s", string(b))
+	}
+
+	af := &AlarmFile{}
+	WriteLog(ERROR, "DB_FAIL", "Failed to connect to db, \"primary\"")
+	g_transport.Send(ALARM_MSG_TYPE, []byte("not an alarm record"), true)
+	af.Process()
+	b, _ = os.ReadFile(filepath.Join(cfg.AlarmKpiPath, QUARANTINE_DIR, QUARANTINE_ALARM_FILE))
+	if string(b) != "not an alarm record\n" {
+		t.Fatalf("record not parsed shall be quarantined but [%s]", string(b))
+	}
+	err = af.ForceFlush()
+	if err != nil {
+		t.Fatalf("ForceFlush: %v", err)
+	}
+	files, _ = filepath.Glob(filepath.Join(cfg.AlarmKpiPath, "*-WARNING-*.jsonl"))
+	if len(files) != 1 {
+		t.Fatalf("unexpected jsonl WARNING files %v", files)
+	}
+	b, _ = os.ReadFile(files[0])
+	var row jsonAlarmRow
+	err = json.Unmarshal(b, &row)
+	if err != nil || row.Oid != "1.3.1.1.1" || row.App != "APPLICATION001" || row.Level != "ERROR" || !strings.HasSuffix(row.Msg, "Failed to connect to db, \"primary\"") {
+		t.Fatalf("unexpected jsonl WARNING file [%s] %v", string(b), err)
+	}
+
+	//a tmp file left by a crash is checked by the configured formatter
+	kpi_tmp := filepath.Join(cfg.AlarmKpiPath, ".kpi.tmp")
+	alarm_tmp := filepath.Join(cfg.AlarmKpiPath, ".alarm.tmp")
+	os.WriteFile(kpi_tmp, []byte(KPI_CSV_HEADER+"\n20160514041500,kpi_collector,KPI,1.3.1.2.1,5\n"), 0644)
+	os.WriteFile(alarm_tmp, []byte("{\"ts\":\"20160514041503\",\"app\":\"APP\""), 0644)
+	actions, err := RecoverTmpFiles()
+	if err != nil || len(actions) != 2 || !strings.HasPrefix(actions[0], "published") || !strings.HasPrefix(actions[1], "quarantined") {
+		t.Fatalf("RecoverTmpFiles: %v %v", actions, err)
+	}
+}
+
+func TestAlarmFormatterRows(t *testing.T) {
+	line := "20160514041503|APP|ERROR|.1.3.1.1.1|Failed, to \"connect\""
+	rec, err := ParseAlarmRecord(line)
+	if err != nil {
+		t.Fatalf("ParseAlarmRecord: %v", err)
+	}
+	if rec.Oid != "1.3.1.1.1" || !rec.Timestamp.Equal(time.Date(2016, 5, 14, 4, 15, 3, 0, time.Local)) {
+		t.Fatalf("unexpected alarm record %+v", rec)
+	}
+	for _, c := range []struct {
+		format string
+		row    string
+	}{
+		{FORMAT_PIPE, line + "\n"},
+		{FORMAT_CSV, "20160514041503,APP,ERROR,1.3.1.1.1,,\"Failed, to \"\"connect\"\"\"\n"},
+		{FORMAT_JSON, `{"ts":"20160514041503","app":"APP","level":"ERROR","oid":"1.3.1.1.1","msg":"Failed, to \"connect\""}` + "\n"},
+	} {
+		f, err := GetAlarmFormatter(c.format)
+		if err != nil {
+			t.Fatalf("GetAlarmFormatter: %v", err)
+		}
+		row := string(f.Row(rec))
+		if row != c.row {
+			t.Fatalf("unexpected %s row [%s]", c.format, row)
+		}
+		if !f.Valid(append(f.Header(), row...)) || f.Valid([]byte(strings.TrimSuffix(row, "\n"))) {
+			t.Fatalf("unexpected %s validation", c.format)
+		}
+	}
+	for content, resource := range map[string]string{
+		"peer down resource=hss01":                     "hss01",
+		`peer down resource="hss 01"`:                  "hss 01",
+		`link resource=a down resource="b resource=c"`: "b resource=c",
+		"resource=hss01 down":                          "",
+	} {
+		rec, err = ParseAlarmRecord("20160514041503|APP|ERROR" + ALARM_STATE_FLAG + "|.1.3.1.1.1|" + content)
+		if err != nil || rec.Resource != resource || rec.Stateful != (len(resource) > 0) || rec.Level != "ERROR" || rec.Oid != "1.3.1.1.1" {
+			t.Fatalf("unexpected resource of [%s] %+v %v", content, rec, err)
+		}
+		//the resource field without the flag, e.g. of WriteLogKV
+		rec, err = ParseAlarmRecord("20160514041503|APP|ERROR|.1.3.1.1.1|" + content)
+		if err != nil || rec.Resource != "" || rec.Stateful {
+			t.Fatalf("unflagged record [%s] taken as stateful %+v %v", content, rec, err)
+		}
+	}
+	_, err = ParseAlarmRecord("20160514041503|APP|ERROR")
+	if err == nil {
+		t.Fatalf("ParseAlarmRecord accepted a partial record")
+	}
+	_, err = GetAlarmFormatter("xml")
+	if err == nil {
+		t.Fatalf("GetAlarmFormatter accepted an unknown format")
+	}
+}
+
+func TestFreeName(t *testing.T) {
+	dir := t.TempDir()
+	at := time.Date(2016, 5, 18, 10, 5, 0, 0, time.Local)
+	name := func(rc int) (string, error) { return stampedFileName("KPI", at, rc, ".txt") }
+	taken, _ := name(0)
+	os.WriteFile(filepath.Join(dir, taken), nil, 0644)
+	os.WriteFile(filepath.Join(dir, strings.TrimSuffix(taken, ".txt")+"_1.txt"), nil, 0644)
+	target, err := freeName(dir, name)
+	if expect := filepath.Join(dir, strings.TrimSuffix(taken, ".txt")+"_2.txt"); err != nil || target != expect {
+		t.Fatalf("expect [%s] but [%s] %v", expect, target, err)
+	}
+
+	//the stat errors other than not exist are returned instead of retried
+	_, err = freeName(filepath.Join(dir, taken), name)
+	if err == nil {
+		t.Fatalf("freeName under a file shall fail")
+	}
+	_, err = freeName(dir, func(rc int) (string, error) { return taken, nil })
+	if err == nil {
+		t.Fatalf("freeName shall give up after %d tries", MAX_FILE_RC+1)
+	}
+}
@@ -0,0 +1,114 @@
+package applog
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+/*Housekeeping policy of the rotated files [file].*/
+type HousekeepPolicy struct {
+	Compress      bool  `json:"compress"`       //gzip the rotated files
+	RetentionDays int   `json:"retention_days"` //days the rotated files retained, 0 for no limit
+	MaxTotalSize  int64 `json:"max_total_size"` //bytes of all the rotated files retained, 0 for no limit
+}
+
+/*Compress the file to [file].gz and remove it, return the compressed filename*/
+func CompressFile(path string) (string, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	target := path + ".gz"
+	tmp := target + ".tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return "", err
+	}
+	zw := gzip.NewWriter(dst)
+	zw.Name = info.Name()
+	zw.ModTime = info.ModTime()
+	_, err = io.Copy(zw, src)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		err = dst.Close()
+	} else {
+		dst.Close()
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return "", errors.New(fmt.Sprintf("CompressFile [%s] failed: %v", path, err))
+	}
+	os.Chtimes(tmp, info.ModTime(), info.ModTime()) //keep the mtime for retention
+	err = os.Rename(tmp, target)
+	if err != nil {
+		os.Remove(tmp)
+		return "", errors.New(fmt.Sprintf("CompressFile [%s] failed: %v", path, err))
+	}
+	os.Remove(path)
+	return target, nil
+}
+
+// Compress the rotated files [path].* and remove those beyond the retention days or total size,
+// every action is logged via WriteLog
+func Housekeep(path string, policy HousekeepPolicy) error {
+	files, err := listRotated(path)
+	if err != nil {
+		WriteLog(WARN, "HOUSEKEEP_FAIL", "Housekeep [%s] failed to list rotated files: %v", path, err)
+		return err
+	}
+	deadline := Now().Add(-time.Duration(policy.RetentionDays) * 24 * time.Hour)
+	total := int64(0)
+	var kept []rotatedFile
+	for _, fi := range files {
+		if strings.HasSuffix(fi.path, ".tmp") {
+			continue //compressing in progress or left by a crash
+		}
+		if policy.RetentionDays > 0 && fi.mtime.Before(deadline) {
+			housekeepRemove(fi.path, "older than %d days", policy.RetentionDays)
+			continue
+		}
+		if policy.Compress && !strings.HasSuffix(fi.path, ".gz") {
+			target, err := CompressFile(fi.path)
+			if err != nil {
+				WriteLog(WARN, "HOUSEKEEP_FAIL", "Housekeep: %v", err)
+			} else {
+				info, err := os.Stat(target)
+				if err == nil {
+					Info("Housekeep: compressed [%s] to [%s], %d -> %d bytes", fi.path, target, fi.size, info.Size())
+					fi.path = target
+					fi.size = info.Size()
+				}
+			}
+		}
+		kept = append(kept, fi)
+	}
+	for _, fi := range kept { //newest first
+		total += fi.size
+		if policy.MaxTotalSize > 0 && total > policy.MaxTotalSize {
+			housekeepRemove(fi.path, "total size exceeds %d bytes", policy.MaxTotalSize)
+		}
+	}
+	return nil
+}
+
+func housekeepRemove(path string, reason string, v ...interface{}) {
+	err := os.Remove(path)
+	if err != nil {
+		WriteLog(WARN, "HOUSEKEEP_FAIL", "Housekeep: failed to remove [%s]: %v", path, err)
+		return
+	}
+	Info("Housekeep: removed [%s], %s", path, fmt.Sprintf(reason, v...))
+}
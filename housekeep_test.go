@@ -0,0 +1,59 @@
+package applog
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHousekeep(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	now := time.Now()
+	for i, day := range []string{"20160518", "20160517", "20160516", "20160501"} {
+		name := path + "." + day
+		os.WriteFile(name, []byte("20160518-150820.047|APPLICATION002|INFO|info log [10238]\n"), 0644)
+		mtime := now.Add(-time.Duration(i) * 24 * time.Hour)
+		if day == "20160501" {
+			mtime = now.Add(-17 * 24 * time.Hour)
+		}
+		os.Chtimes(name, mtime, mtime)
+	}
+	os.WriteFile(path, []byte("current log\n"), 0644)
+
+	err := Housekeep(path, HousekeepPolicy{Compress: true, RetentionDays: 10})
+	if err != nil {
+		t.Fatalf("Housekeep: %v", err)
+	}
+	if _, err := os.Stat(path + ".20160501"); !os.IsNotExist(err) {
+		t.Fatalf("expired file not removed: %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("current log file shall be kept: %v", err)
+	}
+	f, err := os.Open(path + ".20160518.gz")
+	if err != nil {
+		t.Fatalf("compressed file not found: %v", err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("gzip.NewReader: %v", err)
+	}
+	b, _ := io.ReadAll(zr)
+	if string(b) != "20160518-150820.047|APPLICATION002|INFO|info log [10238]\n" {
+		t.Fatalf("unexpected decompressed content [%s]", string(b))
+	}
+
+	//the newest compressed file alone exceeds the budget of 1 byte, all are removed
+	err = Housekeep(path, HousekeepPolicy{MaxTotalSize: 1})
+	if err != nil {
+		t.Fatalf("Housekeep: %v", err)
+	}
+	files, _ := listRotated(path)
+	if len(files) != 0 {
+		t.Fatalf("expect all rotated files removed but %v", files)
+	}
+}
@@ -0,0 +1,163 @@
+package applog
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	KPI_FORMAT_3GPP = "3gpp" //3GPP TS 32.435 measCollecFile in A[date].[begin]-[end]_[hostname].xml
+
+	MEAS_COLLEC_XMLNS   = "http://www.3gpp.org/ftp/specs/archive/32_series/32.435#measCollec"
+	MEAS_FILE_VERSION   = "32.435 V10.0"
+	MEAS_VENDOR_NAME    = "applog"
+	MEAS_INFO_ID        = "kpi_collector"
+	MEAS_TIME_FORMAT    = "2006-01-02T15:04:05-07:00"
+	MEAS_FILENAME_STAMP = "20060102.1504-0700"
+)
+
+type measCollecFile struct {
+	XMLName xml.Name       `xml:"measCollecFile"`
+	Xmlns   string         `xml:"xmlns,attr"`
+	Header  measFileHeader `xml:"fileHeader"`
+	Data    measData       `xml:"measData"`
+	Footer  measFileFooter `xml:"fileFooter"`
+}
+
+type measFileHeader struct {
+	FileFormatVersion string `xml:"fileFormatVersion,attr"`
+	VendorName        string `xml:"vendorName,attr"`
+	FileSender        struct {
+		LocalDn string `xml:"localDn,attr"`
+	} `xml:"fileSender"`
+	MeasCollec struct {
+		BeginTime string `xml:"beginTime,attr"`
+	} `xml:"measCollec"`
+}
+
+type measData struct {
+	ManagedElement struct {
+		LocalDn string `xml:"localDn,attr"`
+	} `xml:"managedElement"`
+	MeasInfo measInfo `xml:"measInfo"`
+}
+
+type measInfo struct {
+	MeasInfoId string `xml:"measInfoId,attr"`
+	GranPeriod struct {
+		Duration string `xml:"duration,attr"`
+		EndTime  string `xml:"endTime,attr"`
+	} `xml:"granPeriod"`
+	RepPeriod struct {
+		Duration string `xml:"duration,attr"`
+	} `xml:"repPeriod"`
+	MeasTypes []measType `xml:"measType"`
+	MeasValue measValue  `xml:"measValue"`
+}
+
+type measType struct {
+	P    int    `xml:"p,attr"`
+	Name string `xml:",chardata"`
+}
+
+type measValue struct {
+	MeasObjLdn string        `xml:"measObjLdn,attr"`
+	Results    []measResults `xml:"r"`
+}
+
+type measResults struct {
+	P     int   `xml:"p,attr"`
+	Value int64 `xml:",chardata"`
+}
+
+type measFileFooter struct {
+	MeasCollec struct {
+		EndTime string `xml:"endTime,attr"`
+	} `xml:"measCollec"`
+}
+
+/*Generate the 3GPP TS 32.432 file name of a single NE, e.g. A20160514.0410+0800-0415+0800_hostname.xml*/
+func GenerateMeasFileName(begin time.Time, end time.Time) (string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("GenerateMeasFileName failed: %v", err))
+	}
+	return fmt.Sprintf("A%s-%s_%s.xml", begin.Format(MEAS_FILENAME_STAMP), end.Format("1504-0700"), hostname), nil
+}
+
+/*Build the measCollecFile of the counters measured in [begin, end), one measType per oid, the granPeriod is the duration measured*/
+func newMeasCollecFile(counters map[string]int64, begin time.Time, end time.Time) (*measCollecFile, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	m := &measCollecFile{Xmlns: MEAS_COLLEC_XMLNS}
+	m.Header.FileFormatVersion = MEAS_FILE_VERSION
+	m.Header.VendorName = MEAS_VENDOR_NAME
+	m.Header.FileSender.LocalDn = hostname
+	m.Header.MeasCollec.BeginTime = begin.Format(MEAS_TIME_FORMAT)
+	m.Data.ManagedElement.LocalDn = hostname
+	info := &m.Data.MeasInfo
+	info.MeasInfoId = MEAS_INFO_ID
+	info.GranPeriod.Duration = fmt.Sprintf("PT%dS", end.Unix()-begin.Unix())
+	info.GranPeriod.EndTime = end.Format(MEAS_TIME_FORMAT)
+	info.RepPeriod.Duration = info.GranPeriod.Duration
+	info.MeasValue.MeasObjLdn = hostname
+	for i, oid := range sortedOids(counters) {
+		info.MeasTypes = append(info.MeasTypes, measType{P: i + 1, Name: oid})
+		info.MeasValue.Results = append(info.MeasValue.Results, measResults{P: i + 1, Value: counters[oid]})
+	}
+	m.Footer.MeasCollec.EndTime = end.Format(MEAS_TIME_FORMAT)
+	return m, nil
+}
+
+/*the 3GPP TS 32.435 measCollecFile of the 3gpp kpi_format*/
+type measKpiFormatter struct{}
+
+/*A[begin]-[end]_[hostname].xml, with the running count "_-_rc" of TS 32.432 appended if rc > 0*/
+func (self *measKpiFormatter) FileName(begin time.Time, end time.Time, rc int) (string, error) {
+	filename, err := GenerateMeasFileName(begin, end)
+	if err != nil || rc == 0 {
+		return filename, err
+	}
+	return fmt.Sprintf("%s_-_%d.xml", strings.TrimSuffix(filename, ".xml"), rc), nil
+}
+
+func (self *measKpiFormatter) Format(counters map[string]int64, begin time.Time, end time.Time) ([]byte, error) {
+	m, err := newMeasCollecFile(counters, begin, end)
+	if err != nil {
+		return nil, err
+	}
+	b, err := xml.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(b, '\n')...), nil
+}
+
+func (self *measKpiFormatter) Valid(content []byte) bool {
+	_, _, err := self.Period(content)
+	return err == nil
+}
+
+/*Return the begin and end of the interval of the measCollecFile*/
+func (self *measKpiFormatter) Period(content []byte) (time.Time, time.Time, error) {
+	var m measCollecFile
+	err := xml.Unmarshal(content, &m)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	begin, err := time.Parse(MEAS_TIME_FORMAT, m.Header.MeasCollec.BeginTime)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	end, err := time.Parse(MEAS_TIME_FORMAT, m.Footer.MeasCollec.EndTime)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return begin, end, nil
+}
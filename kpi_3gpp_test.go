@@ -0,0 +1,90 @@
+package applog
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMeasCollecFile(t *testing.T) {
+	cfg := loadTestCfg(t, 7905, func(cfg *LogCfg) {
+		cfg.KpiFormat = KPI_FORMAT_3GPP
+	})
+	kf, err := NewKpiFile()
+	if err != nil {
+		t.Fatalf("NewKpiFile: %v", err)
+	}
+	interval := time.Duration(cfg.KpiInterval) * time.Second
+	kf.schedule = NewFlushSchedule(cfg.KpiInterval, time.Now().Add(-interval))
+	end := kf.schedule.Next()
+	begin := end.Add(-interval)
+	WriteKpi("REQ_COUNT", 5)
+	kf.Process()
+	err = kf.Flush()
+	if err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	filename, _ := GenerateMeasFileName(begin, end)
+	if !strings.HasPrefix(filename, "A"+begin.Format("20060102.1504-0700")+"-"+end.Format("1504-0700")+"_") {
+		t.Fatalf("unexpected 3gpp file name [%s]", filename)
+	}
+	b, err := os.ReadFile(filepath.Join(cfg.AlarmKpiPath, filename))
+	if err != nil {
+		t.Fatalf("measCollecFile not written: %v", err)
+	}
+	if !strings.HasPrefix(string(b), xml.Header+"<measCollecFile xmlns=\""+MEAS_COLLEC_XMLNS+"\">") {
+		t.Fatalf("unexpected measCollecFile\n%s", string(b))
+	}
+	var m measCollecFile
+	err = xml.Unmarshal(b, &m)
+	if err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	info := m.Data.MeasInfo
+	if m.Header.MeasCollec.BeginTime != begin.Format(MEAS_TIME_FORMAT) || info.GranPeriod.EndTime != end.Format(MEAS_TIME_FORMAT) || info.GranPeriod.Duration != "PT300S" {
+		t.Fatalf("unexpected period [%s] [%s] [%s]", m.Header.MeasCollec.BeginTime, info.GranPeriod.EndTime, info.GranPeriod.Duration)
+	}
+	if len(info.MeasTypes) != 2 || info.MeasTypes[0].Name != "1.3.1.2.1" || info.MeasValue.Results[0].Value != 5 || info.MeasValue.Results[1].Value != 0 {
+		t.Fatalf("unexpected measInfo %+v", info)
+	}
+
+	//the partial interval is checkpointed on shutdown instead of written
+	WriteKpi("REQ_COUNT", 3)
+	kf.Process()
+	err = kf.ForceFlush()
+	if err != nil {
+		t.Fatalf("ForceFlush: %v", err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(cfg.AlarmKpiPath, "A*.xml")); len(files) != 1 {
+		t.Fatalf("partial measCollecFile written on shutdown: %v", files)
+	}
+	restarted, _ := NewKpiFile()
+	restarted.schedule = kf.schedule
+	restarted.interval_start = kf.interval_start
+	err = restarted.Restore()
+	if err != nil || restarted.Counters()["1.3.1.2.1"] != 3 {
+		t.Fatalf("counters of the partial interval not restored: %v %v", restarted.Counters(), err)
+	}
+
+	//a partial period declares its real duration
+	partial, _ := newMeasCollecFile(map[string]int64{"1.3.1.2.1": 1}, begin, begin.Add(120*time.Second))
+	if partial.Data.MeasInfo.GranPeriod.Duration != "PT120S" {
+		t.Fatalf("unexpected duration of partial period [%s]", partial.Data.MeasInfo.GranPeriod.Duration)
+	}
+
+	//a complete tmp file left by a crash is published under its period
+	tmp := filepath.Join(cfg.AlarmKpiPath, ".kpi.tmp")
+	os.WriteFile(tmp, b, 0644)
+	actions, err := RecoverTmpFiles()
+	if err != nil || len(actions) != 1 {
+		t.Fatalf("RecoverTmpFiles: %v %v", actions, err)
+	}
+	_, err = os.Stat(filepath.Join(cfg.AlarmKpiPath, strings.TrimSuffix(filename, ".xml")+"_-_1.xml"))
+	if err != nil {
+		t.Fatalf("recovered measCollecFile not published: %v %v", actions, err)
+	}
+}
@@ -0,0 +1,103 @@
+package applog
+
+import (
+	"fmt"
+	"time"
+)
+
+// accumulate the kpi deltas per oid and send one record per oid per window, 0 to send a record per call,
+// the kpi_batch_ms item of the config by default
+func WithKpiBatch(window time.Duration) LoggerOption {
+	return func(l *Logger) {
+		l.kpi_window = window
+	}
+}
+
+/*the window to accumulate the kpi deltas, 0 if not batched*/
+func (self *Logger) kpiWindow() time.Duration {
+	if self.kpi_window > 0 {
+		return self.kpi_window
+	}
+	if self.cfg != nil && self.cfg.KpiBatchWindow > 0 {
+		return time.Duration(self.cfg.KpiBatchWindow) * time.Millisecond
+	}
+	return 0
+}
+
+/*send or accumulate the delta of the oid, the mutex shall be held by the caller*/
+func (self *Logger) sendDelta(oid string, delta int64) {
+	window := self.kpiWindow()
+	if window <= 0 {
+		kpi_line := fmt.Sprintf("%s|%d", oid, delta)
+		//fmt.Printf("WriteKpi [%s][%v]\n", kpi_line, []byte(kpi_line))
+		self.transport.Send(KPI_MSG_TYPE, []byte(kpi_line), true)
+		return
+	}
+	if self.kpi_pending == nil {
+		self.kpi_pending = make(map[string]int64)
+	}
+	self.kpi_pending[oid] += delta
+	if self.stop_kpi == nil {
+		self.stop_kpi = make(chan struct{})
+		go self.kpiBatchRoutine(self.stop_kpi, window)
+	}
+}
+
+/*send the accumulated deltas of the oids, all if no oid given, the mutex shall be held by the caller*/
+func (self *Logger) flushKpi(oids ...string) {
+	if len(self.kpi_pending) == 0 || self.transport == nil {
+		return
+	}
+	if len(oids) == 0 {
+		for oid, _ := range self.kpi_pending {
+			oids = append(oids, oid)
+		}
+	}
+	for _, oid := range oids {
+		delta, present := self.kpi_pending[oid]
+		if !present {
+			continue
+		}
+		delete(self.kpi_pending, oid)
+		if delta != 0 {
+			self.transport.Send(KPI_MSG_TYPE, []byte(fmt.Sprintf("%s|%d", oid, delta)), true)
+		}
+	}
+}
+
+/*send the accumulated deltas per window*/
+func (self *Logger) kpiBatchRoutine(stop chan struct{}, window time.Duration) {
+	ticker := time.NewTicker(window)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			self.mutex.Lock()
+			self.flushKpi()
+			self.mutex.Unlock()
+		}
+	}
+}
+
+/*stop the batch routine and send the accumulated deltas, the mutex shall be held by the caller*/
+func (self *Logger) closeKpiBatch() {
+	if self.stop_kpi != nil {
+		close(self.stop_kpi)
+		self.stop_kpi = nil
+	}
+	self.flushKpi()
+}
+
+/*Send the accumulated kpi deltas now*/
+func (self *Logger) FlushKpi() {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.flushKpi()
+}
+
+/*Send the accumulated kpi deltas of the default Logger now*/
+func FlushKpi() {
+	g_logger.FlushKpi()
+}
@@ -0,0 +1,79 @@
+package applog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKpiBatch(t *testing.T) {
+	cfg := &LogCfg{
+		MQID:      7902,
+		Transport: TRANSPORT_MEMORY,
+		KpiOid:    map[string]string{"REQ_COUNT": "1.3.1.2.1", "SESSIONS": "1.3.1.2.5"},
+		KpiType:   map[string]string{"SESSIONS": KPI_UPDOWN},
+	}
+	l, err := NewLogger(cfg, "APPLICATION001", WithKpiBatch(time.Hour))
+	if err != nil {
+		t.Fatalf("NewLogger: %v", err)
+	}
+	defer l.Close()
+	mq := NewMemTransport(7902)
+	for i := 0; i < 1000; i++ {
+		l.IncreaseKpi("REQ_COUNT")
+	}
+	l.IncreaseKpi("SESSIONS")
+	l.IncreaseKpi("SESSIONS")
+	if recs := mq.Drain(KPI_MSG_TYPE); len(recs) != 0 {
+		t.Fatalf("deltas shall be accumulated in the window but sent %d records", len(recs))
+	}
+	//the deltas before SetKpi are sent before the value
+	l.SetKpi("SESSIONS", 10)
+	l.DecreaseKpi("SESSIONS")
+	recs := mq.Drain(KPI_MSG_TYPE)
+	if len(recs) != 2 || string(recs[0]) != "1.3.1.2.5|2" || string(recs[1]) != "1.3.1.2.5|=10" {
+		t.Fatalf("unexpected records on SetKpi %q", recs)
+	}
+	//the accumulated deltas are sent on Close
+	l.Close()
+	recs = mq.Drain(KPI_MSG_TYPE)
+	if len(recs) != 2 {
+		t.Fatalf("expect one record per oid on Close but %q", recs)
+	}
+	for _, rec := range recs {
+		if string(rec) != "1.3.1.2.1|1000" && string(rec) != "1.3.1.2.5|-1" {
+			t.Fatalf("unexpected record %s", rec)
+		}
+	}
+}
+
+/*IncreaseKpi and the KpiFile processing of the records, with the kpi deltas batched per window_ms*/
+func benchmarkIncreaseKpi(b *testing.B, window_ms int64) {
+	loadTestCfg(b, 7903, func(cfg *LogCfg) {
+		cfg.KpiBatchWindow = window_ms
+	})
+	kf, err := NewKpiFile()
+	if err != nil {
+		b.Fatalf("NewKpiFile: %v", err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		IncreaseKpi("REQ_COUNT")
+		if i%10000 == 9999 {
+			kf.Process()
+		}
+	}
+	FlushKpi()
+	kf.Process()
+	b.StopTimer()
+	if kf.Counters()["1.3.1.2.1"] != int64(b.N) {
+		b.Fatalf("expect %d but %v", b.N, kf.Counters())
+	}
+}
+
+func BenchmarkIncreaseKpi(b *testing.B) {
+	benchmarkIncreaseKpi(b, 0)
+}
+
+func BenchmarkIncreaseKpiBatch(b *testing.B) {
+	benchmarkIncreaseKpi(b, 100)
+}
@@ -0,0 +1,116 @@
+package applog
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+const (
+	KPI_HISTOGRAM = "histogram" //distribution of the values observed in the interval
+
+	KPI_OBSERVE_PREFIX = "~" //kpi record "oid|~value" observes the value of a histogram kpi
+
+	//sub-oids of the rows written for a histogram kpi, e.g. 1.3.1.2.9.1 for the count of 1.3.1.2.9
+	KPI_SUB_COUNT      = ".1"
+	KPI_SUB_SUM        = ".2"
+	KPI_SUB_MIN        = ".3"
+	KPI_SUB_MAX        = ".4"
+	KPI_SUB_AVG        = ".5"
+	KPI_SUB_BUCKET     = ".6." //followed by the 1-based bucket index, the last one counts the values above all the boundaries
+	KPI_SUB_PERCENTILE = ".7." //followed by the percentile, estimated as the upper boundary of the bucket it falls in
+)
+
+/*the percentiles written for a histogram kpi with buckets*/
+var KPI_PERCENTILES = []int64{50, 90, 99}
+
+/*values observed for a histogram kpi in an interval*/
+type kpiHistogram struct {
+	Count   int64   `json:"count"`
+	Sum     int64   `json:"sum"`
+	Min     int64   `json:"min"`
+	Max     int64   `json:"max"`
+	Buckets []int64 `json:"buckets"` //value count per bucket, one more than the boundaries
+}
+
+func newKpiHistogram(bounds []int64) *kpiHistogram {
+	return &kpiHistogram{Buckets: make([]int64, len(bounds)+1)}
+}
+
+/*Check the bucket boundaries are strictly ascending*/
+func validBuckets(bounds []int64) error {
+	for i := 1; i < len(bounds); i++ {
+		if bounds[i] <= bounds[i-1] {
+			return errors.New(fmt.Sprintf("bucket boundaries %v shall be strictly ascending", bounds))
+		}
+	}
+	return nil
+}
+
+/*Add the value to the bucket of the first boundary not less than it*/
+func (self *kpiHistogram) observe(v int64, bounds []int64) {
+	if self.Count == 0 || v < self.Min {
+		self.Min = v
+	}
+	if self.Count == 0 || v > self.Max {
+		self.Max = v
+	}
+	self.Count++
+	self.Sum += v
+	self.Buckets[sort.Search(len(bounds), func(i int) bool { return bounds[i] >= v })]++
+}
+
+/*Merge the values of other of the same boundaries into this one*/
+func (self *kpiHistogram) merge(other *kpiHistogram) {
+	if other.Count == 0 {
+		return
+	}
+	if self.Count == 0 || other.Min < self.Min {
+		self.Min = other.Min
+	}
+	if self.Count == 0 || other.Max > self.Max {
+		self.Max = other.Max
+	}
+	self.Count += other.Count
+	self.Sum += other.Sum
+	for i := range self.Buckets {
+		self.Buckets[i] += other.Buckets[i]
+	}
+}
+
+/*Estimate the p-th percentile as the upper boundary of the bucket it falls in, capped by the max*/
+func (self *kpiHistogram) percentile(p int64, bounds []int64) int64 {
+	rank := (self.Count*p + 99) / 100
+	seen := int64(0)
+	for i, n := range self.Buckets {
+		seen += n
+		if seen >= rank && i < len(bounds) && bounds[i] < self.Max {
+			return bounds[i]
+		}
+		if seen >= rank {
+			break
+		}
+	}
+	return self.Max
+}
+
+/*Add the rows of the histogram under the sub-oids of oid*/
+func (self *kpiHistogram) rows(oid string, bounds []int64, rows map[string]int64) {
+	rows[oid+KPI_SUB_COUNT] = self.Count
+	rows[oid+KPI_SUB_SUM] = self.Sum
+	rows[oid+KPI_SUB_MIN] = self.Min
+	rows[oid+KPI_SUB_MAX] = self.Max
+	rows[oid+KPI_SUB_AVG] = 0
+	if self.Count > 0 {
+		rows[oid+KPI_SUB_AVG] = self.Sum / self.Count
+	}
+	if len(bounds) == 0 {
+		return
+	}
+	for i, n := range self.Buckets {
+		rows[fmt.Sprintf("%s%s%d", oid, KPI_SUB_BUCKET, i+1)] = n
+	}
+	for _, p := range KPI_PERCENTILES {
+		rows[fmt.Sprintf("%s%s%d", oid, KPI_SUB_PERCENTILE, p)] = self.percentile(p, bounds)
+	}
+}
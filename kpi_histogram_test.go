@@ -0,0 +1,55 @@
+package applog
+
+import (
+	"testing"
+)
+
+func TestKpiHistogram(t *testing.T) {
+	loadTestCfg(t, 7899, func(cfg *LogCfg) {
+		cfg.KpiOid["LATENCY"] = "1.3.1.2.9"
+		cfg.KpiType = map[string]string{"LATENCY": KPI_HISTOGRAM}
+		cfg.KpiBuckets = map[string][]int64{"LATENCY": {10, 50, 100}}
+	})
+	kf, err := NewKpiFile()
+	if err != nil {
+		t.Fatalf("NewKpiFile: %v", err)
+	}
+	for _, v := range []int64{5, 8, 20, 30, 40, 45, 60, 70, 90, 300} {
+		err = ObserveKpi("LATENCY", v)
+		if err != nil {
+			t.Fatalf("ObserveKpi: %v", err)
+		}
+	}
+	if ObserveKpi("REQ_COUNT", 1) == nil {
+		t.Fatalf("ObserveKpi shall fail on a counter")
+	}
+	WriteKpi("LATENCY", 1) //ignored
+	kf.Process()
+	counters := kf.Counters()
+	expect := map[string]int64{
+		"1.3.1.2.9.1":    10,
+		"1.3.1.2.9.2":    668,
+		"1.3.1.2.9.3":    5,
+		"1.3.1.2.9.4":    300,
+		"1.3.1.2.9.5":    66,
+		"1.3.1.2.9.6.1":  2,
+		"1.3.1.2.9.6.2":  4,
+		"1.3.1.2.9.6.3":  3,
+		"1.3.1.2.9.6.4":  1,
+		"1.3.1.2.9.7.50": 50,
+		"1.3.1.2.9.7.90": 100,
+		"1.3.1.2.9.7.99": 300,
+	}
+	for oid, v := range expect {
+		if counters[oid] != v {
+			t.Fatalf("expect [%s] %d but %d: %v", oid, v, counters[oid], counters)
+		}
+	}
+
+	//an interval without observations has zero rows
+	kf.reset()
+	counters = kf.Counters()
+	if counters["1.3.1.2.9.1"] != 0 || counters["1.3.1.2.9.3"] != 0 || counters["1.3.1.2.9.7.99"] != 0 {
+		t.Fatalf("unexpected rows of empty histogram %v", counters)
+	}
+}
@@ -0,0 +1,79 @@
+package applog
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	KPI_COUNTER = "counter" //sum of the deltas, reset to 0 each interval
+	KPI_UPDOWN  = "updown"  //sum of the deltas, carried over to the next interval
+	KPI_GAUGE   = "gauge"   //last value set, carried over to the next interval
+
+	KPI_SET_PREFIX = "=" //kpi record "oid|=value" sets the value instead of adding the delta
+)
+
+/*Check the kind of every kpi declared in "kpi_type" and the buckets in "kpi_buckets", and build the maps by oid*/
+func (self *LogCfg) loadKpiKinds() error {
+	self.kpi_kinds = make(map[string]string)
+	for name, kind := range self.KpiType {
+		switch kind {
+		case KPI_COUNTER, KPI_UPDOWN, KPI_GAUGE, KPI_HISTOGRAM:
+		default:
+			return errors.New(fmt.Sprintf("kpi_type: invalid kind [%s] of KPI [%s], expect counter, updown, gauge or histogram", kind, name))
+		}
+		oid, present := self.KpiOid[name]
+		if !present {
+			return errors.New(fmt.Sprintf("kpi_type: KPI [%s] not found oid", name))
+		}
+		self.kpi_kinds[oid] = kind
+	}
+	self.kpi_buckets = make(map[string][]int64)
+	for name, bounds := range self.KpiBuckets {
+		if self.KpiType[name] != KPI_HISTOGRAM {
+			return errors.New(fmt.Sprintf("kpi_buckets: KPI [%s] is not a histogram", name))
+		}
+		err := validBuckets(bounds)
+		if err != nil {
+			return errors.New(fmt.Sprintf("kpi_buckets: KPI [%s] %v", name, err))
+		}
+		self.kpi_buckets[self.KpiOid[name]] = bounds
+	}
+	return nil
+}
+
+/*Build the kind maps once for a config not loaded from file, e.g. built in code and passed to NewLogger*/
+func (self *LogCfg) buildKpiKinds() error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.kpi_kinds != nil {
+		return nil
+	}
+	err := self.loadKpiKinds()
+	if err == nil {
+		err = self.loadKpiLabels()
+	}
+	if err != nil {
+		self.kpi_kinds = nil
+		return errors.New(fmt.Sprintf("invalid kpi config: %v", err))
+	}
+	return nil
+}
+
+/*Return the bucket boundaries of the histogram kpi oid*/
+func (self *LogCfg) GetKpiBuckets(oid string) []int64 {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.kpi_buckets[oid]
+}
+
+/*Return the kind of the kpi oid, counter if not declared*/
+func (self *LogCfg) GetKpiKind(oid string) string {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	kind, present := self.kpi_kinds[oid]
+	if present {
+		return kind
+	}
+	return KPI_COUNTER
+}
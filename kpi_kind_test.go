@@ -0,0 +1,64 @@
+package applog
+
+import (
+	"testing"
+)
+
+func TestKpiKinds(t *testing.T) {
+	loadTestCfg(t, 7898, func(cfg *LogCfg) {
+		cfg.KpiOid["SESSIONS"] = "1.3.1.2.5"
+		cfg.KpiOid["QUEUE_LEN"] = "1.3.1.2.7"
+		cfg.KpiType = map[string]string{"SESSIONS": KPI_UPDOWN, "QUEUE_LEN": KPI_GAUGE}
+	})
+	kf, err := NewKpiFile()
+	if err != nil {
+		t.Fatalf("NewKpiFile: %v", err)
+	}
+	IncreaseKpi("REQ_COUNT")
+	IncreaseKpi("REQ_COUNT")
+	DecreaseKpi("REQ_COUNT")
+	IncreaseKpi("SESSIONS")
+	IncreaseKpi("SESSIONS")
+	DecreaseKpi("SESSIONS")
+	SetKpi("QUEUE_LEN", 8)
+	SetKpi("QUEUE_LEN", 5)
+	if SetKpi("REQ_COUNT", 3) == nil {
+		t.Fatalf("SetKpi shall fail on a counter")
+	}
+	kf.Process()
+	counters := kf.Counters()
+	if counters["1.3.1.2.1"] != 1 || counters["1.3.1.2.5"] != 1 || counters["1.3.1.2.7"] != 5 {
+		t.Fatalf("unexpected counters %v", counters)
+	}
+
+	//the counter restarts from 0 in the next interval, the updown and gauge kpis keep their values
+	kf.reset()
+	IncreaseKpi("SESSIONS")
+	kf.Process()
+	counters = kf.Counters()
+	if counters["1.3.1.2.1"] != 0 || counters["1.3.1.2.5"] != 2 || counters["1.3.1.2.7"] != 5 {
+		t.Fatalf("unexpected counters of next interval %v", counters)
+	}
+}
+
+func TestKpiKindInvalid(t *testing.T) {
+	cfg := &LogCfg{
+		KpiOid:  map[string]string{"REQ_COUNT": "1.3.1.2.1"},
+		KpiType: map[string]string{"REQ_COUNT": "summary"},
+	}
+	if cfg.loadKpiKinds() == nil {
+		t.Fatalf("invalid kpi kind shall be rejected")
+	}
+	cfg.KpiType = map[string]string{"RES_COUNT": KPI_GAUGE}
+	if cfg.loadKpiKinds() == nil {
+		t.Fatalf("kpi kind of unknown kpi shall be rejected")
+	}
+	//a config built in code is checked by NewLogger
+	cfg = &LogCfg{
+		KpiOid:  map[string]string{"REQ_COUNT": "1.3.1.2.1"},
+		KpiType: map[string]string{"REQ_COUNT": "summary"},
+	}
+	if _, err := NewLogger(cfg, "APPLICATION001"); err == nil {
+		t.Fatalf("NewLogger accepted invalid kpi kind")
+	}
+}
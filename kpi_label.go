@@ -0,0 +1,161 @@
+package applog
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+const (
+	KPI_LABEL_DEFAULT = "*" //label value matching the values not configured
+)
+
+var kpi_suffix_re = regexp.MustCompile(`^[0-9]+(\.[0-9]+)*$`)
+
+/*A label of a kpi, its values are mapped to oid suffixes*/
+type KpiLabel struct {
+	Label  string            `json:"label"`
+	Values map[string]string `json:"values"` //label value to oid suffix, "*" for the values not listed, every label shall be given a value
+}
+
+func (self *KpiLabel) Validate() error {
+	if len(self.Label) < 1 {
+		return errors.New("kpi_labels: empty label")
+	}
+	if len(self.Values) < 1 {
+		return errors.New(fmt.Sprintf("kpi_labels: label [%s] has no values", self.Label))
+	}
+	for v, suffix := range self.Values {
+		if !kpi_suffix_re.MatchString(suffix) {
+			return errors.New(fmt.Sprintf("kpi_labels: invalid oid suffix [%s] of label [%s] value [%s]", suffix, self.Label, v))
+		}
+	}
+	return nil
+}
+
+/*Return the oid suffix of the label value*/
+func (self *KpiLabel) suffix(value string) (string, bool) {
+	suffix, present := self.Values[value]
+	if !present {
+		suffix, present = self.Values[KPI_LABEL_DEFAULT]
+	}
+	return suffix, present
+}
+
+/*Check the labels of every kpi declared in "kpi_labels", and map the oid of each label combination to the kind of the kpi*/
+func (self *LogCfg) loadKpiLabels() error {
+	for name, labels := range self.KpiLabels {
+		oid, present := self.KpiOid[name]
+		if !present {
+			return errors.New(fmt.Sprintf("kpi_labels: KPI [%s] not found oid", name))
+		}
+		kind := self.KpiType[name]
+		if kind == KPI_HISTOGRAM {
+			return errors.New(fmt.Sprintf("kpi_labels: KPI [%s] is a histogram", name))
+		}
+		for i := range labels {
+			err := labels[i].Validate()
+			if err != nil {
+				return err
+			}
+		}
+		if len(kind) < 1 {
+			continue
+		}
+		for _, label_oid := range labelOids(oid, labels) {
+			self.kpi_kinds[label_oid] = kind
+		}
+	}
+	return self.checkKpiOids()
+}
+
+/*Check no oid is shared by two kpis, including the oids of the label combinations and the histogram sub-oids*/
+func (self *LogCfg) checkKpiOids() error {
+	owners := make(map[string]string)
+	for name, oid := range self.KpiOid {
+		oids := []string{oid}
+		if self.KpiType[name] == KPI_HISTOGRAM {
+			rows := make(map[string]int64)
+			newKpiHistogram(self.KpiBuckets[name]).rows(oid, self.KpiBuckets[name], rows)
+			for sub_oid, _ := range rows {
+				oids = append(oids, sub_oid)
+			}
+		} else if labels := self.KpiLabels[name]; len(labels) > 0 {
+			oids = append(oids, labelOids(oid, labels)...)
+		}
+		for _, o := range oids {
+			owner, present := owners[o]
+			if present && owner != name {
+				return errors.New(fmt.Sprintf("kpi_oid: oid [%s] of KPI [%s] collides with KPI [%s]", o, name, owner))
+			}
+			owners[o] = name
+		}
+	}
+	return nil
+}
+
+/*Return the oid of the kpi with the labels, the suffixes follow the oid in the configured label order*/
+func (self *LogCfg) GetKpiLabelOid(kpi_name string, labels map[string]string) (string, error) {
+	oid, err := self.GetKpiOid(kpi_name)
+	if err != nil {
+		return "", err
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	defs := self.KpiLabels[kpi_name]
+	for label, _ := range labels {
+		configured := false
+		for _, def := range defs {
+			configured = configured || def.Label == label
+		}
+		if !configured {
+			return "", errors.New(fmt.Sprintf("KPI [%s] label [%s] not configured", kpi_name, label))
+		}
+	}
+	for _, def := range defs {
+		value, present := labels[def.Label]
+		if !present {
+			return "", errors.New(fmt.Sprintf("KPI [%s] label [%s] missing value", kpi_name, def.Label))
+		}
+		suffix, present := def.suffix(value)
+		if !present {
+			return "", errors.New(fmt.Sprintf("KPI [%s] label [%s] value [%s] not found oid suffix", kpi_name, def.Label, labels[def.Label]))
+		}
+		oid += "." + suffix
+	}
+	return oid, nil
+}
+
+/*Return the oids of all the kpis, including every label combination of the labelled kpis*/
+func (self *LogCfg) GetKpiOids() []string {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	var oids []string
+	for name, oid := range self.KpiOid {
+		oids = append(oids, oid)
+		if labels := self.KpiLabels[name]; len(labels) > 0 {
+			oids = append(oids, labelOids(oid, labels)...)
+		}
+	}
+	return oids
+}
+
+/*enumerate the oids of the label combinations*/
+func labelOids(oid string, labels []KpiLabel) []string {
+	oids := []string{oid}
+	for _, def := range labels {
+		var next []string
+		seen := make(map[string]bool)
+		for _, suffix := range def.Values {
+			if seen[suffix] {
+				continue //several values may share a suffix
+			}
+			seen[suffix] = true
+			for _, prefix := range oids {
+				next = append(next, prefix+"."+suffix)
+			}
+		}
+		oids = next
+	}
+	return oids
+}
@@ -0,0 +1,77 @@
+package applog
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKpiLabels(t *testing.T) {
+	cfg := loadTestCfg(t, 7900, func(cfg *LogCfg) {
+		cfg.KpiLabels = map[string][]KpiLabel{"REQ_COUNT": {
+			{Label: "peer", Values: map[string]string{"hss01": "1", "hss02": "2"}},
+			{Label: "result", Values: map[string]string{"2001": "1", "*": "9"}},
+		}}
+	})
+	kf, err := NewKpiFile()
+	if err != nil {
+		t.Fatalf("NewKpiFile: %v", err)
+	}
+	WriteKpiLabels("REQ_COUNT", 1, map[string]string{"peer": "hss01", "result": "2001"})
+	WriteKpiLabels("REQ_COUNT", 2, map[string]string{"peer": "hss01", "result": "2001"})
+	WriteKpiLabels("REQ_COUNT", 1, map[string]string{"peer": "hss02", "result": "5012"})
+	if WriteKpiLabels("REQ_COUNT", 1, map[string]string{"peer": "hss03", "result": "2001"}) == nil {
+		t.Fatalf("WriteKpiLabels shall fail on a value without oid suffix")
+	}
+	if WriteKpiLabels("REQ_COUNT", 1, map[string]string{"peer": "hss01", "host": "a"}) == nil {
+		t.Fatalf("WriteKpiLabels shall fail on a label not configured")
+	}
+	if WriteKpiLabels("REQ_COUNT", 1, map[string]string{"peer": "hss01"}) == nil {
+		t.Fatalf("WriteKpiLabels shall fail on a missing label value")
+	}
+	kf.Process()
+	counters := kf.Counters()
+	if counters["1.3.1.2.1.1.1"] != 3 || counters["1.3.1.2.1.2.9"] != 1 || counters["1.3.1.2.1"] != 0 {
+		t.Fatalf("unexpected counters %v", counters)
+	}
+
+	//one row per label combination
+	end := time.Unix(1463199300, 0)
+	err = writeKpiFile(kf.Counters(), end.Add(-300*time.Second), end)
+	if err != nil {
+		t.Fatalf("writeKpiFile: %v", err)
+	}
+	filename, _ := GenerateFileNameAt("KPI", end)
+	b, err := os.ReadFile(filepath.Join(cfg.AlarmKpiPath, filename))
+	if err != nil {
+		t.Fatalf("KPI file not written: %v", err)
+	}
+	var oids []string
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		oids = append(oids, strings.Split(line, "|")[3])
+	}
+	expect := "1.3.1.2.1 1.3.1.2.1.1.1 1.3.1.2.1.1.9 1.3.1.2.1.2.1 1.3.1.2.1.2.9 1.3.1.2.3"
+	if strings.Join(oids, " ") != expect {
+		t.Fatalf("expect rows of [%s] but [%s]", expect, strings.Join(oids, " "))
+	}
+}
+
+func TestKpiOidCollision(t *testing.T) {
+	for _, cfg := range []*LogCfg{
+		{
+			KpiOid:    map[string]string{"REQ_COUNT": "1.3.1.2.1", "REQ_HSS01": "1.3.1.2.1.1"},
+			KpiLabels: map[string][]KpiLabel{"REQ_COUNT": {{Label: "peer", Values: map[string]string{"hss01": "1"}}}},
+		},
+		{
+			KpiOid:     map[string]string{"LATENCY": "1.3.1.2.7", "LATENCY_COUNT": "1.3.1.2.7.1"},
+			KpiType:    map[string]string{"LATENCY": KPI_HISTOGRAM},
+			KpiBuckets: map[string][]int64{"LATENCY": {10, 100}},
+		},
+	} {
+		if cfg.buildKpiKinds() == nil {
+			t.Fatalf("oid collision not rejected in %v", cfg.KpiOid)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package applog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	KPI_STATE_FILE          = ".kpi.state"
+	KPI_CHECKPOINT_INTERVAL = int64(1) //seconds between checkpoints while the counters change
+)
+
+/*checkpoint of the partial kpi counters*/
+type kpiState struct {
+	IntervalStart int64                    `json:"interval_start"`
+	FlushedEnd    int64                    `json:"flushed_end,omitempty"` //end of the last interval written to KPI file
+	Counters      map[string]int64         `json:"counters"`
+	Histograms    map[string]*kpiHistogram `json:"histograms,omitempty"`
+}
+
+/*Save the partial counters with the interval start to the state file under alarm_kpi_path*/
+func (self *KpiFile) Checkpoint() error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.checkpoint()
+}
+
+/*save the state, the mutex shall be held by the caller*/
+func (self *KpiFile) checkpoint() error {
+	b, err := json.Marshal(&kpiState{self.interval_start, self.flushed_end, self.counters, self.histograms})
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(g_log_cfg.AlarmKpiPath, KPI_STATE_FILE)
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, b, 0644)
+	if err != nil {
+		return errors.New(fmt.Sprintf("KpiFile::Checkpoint failed: %v", err))
+	}
+	err = os.Rename(tmp, path)
+	if err != nil {
+		return errors.New(fmt.Sprintf("KpiFile::Checkpoint failed: %v", err))
+	}
+	self.dirty = false
+	self.last_save = Now().Unix()
+	return nil
+}
+
+// Restore the counters checkpointed by last run. If the checkpointed interval is
+// still current, the counters are merged into this one, otherwise they are
+// written as the KPI file of that stale interval, unless it ends at or before the
+// last interval flushed.
+func (self *KpiFile) Restore() error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	path := filepath.Join(g_log_cfg.AlarmKpiPath, KPI_STATE_FILE)
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.New(fmt.Sprintf("KpiFile::Restore failed: %v", err))
+	}
+	var state kpiState
+	err = json.Unmarshal(b, &state)
+	if err != nil {
+		WriteLog(WARN, NO_ALARM, "KpiFile::Restore ignore corrupted state file [%s]: %v", path, err)
+		return nil
+	}
+
+	counters := make(map[string]int64)
+	not_zero := false
+	for oid, v := range state.Counters {
+		_, present := self.counters[oid]
+		if !present {
+			WriteLog(WARN, NO_ALARM, "KpiFile::Restore drop unknown oid [%s] [%d]", oid, v)
+			continue
+		}
+		counters[oid] = v
+		if v != 0 {
+			not_zero = true
+		}
+	}
+	histograms := make(map[string]*kpiHistogram)
+	for oid, h := range state.Histograms {
+		current, present := self.histograms[oid]
+		if !present || len(h.Buckets) != len(current.Buckets) {
+			WriteLog(WARN, NO_ALARM, "KpiFile::Restore drop unknown histogram oid [%s] [%d]", oid, h.Count)
+			continue
+		}
+		histograms[oid] = h
+		if h.Count != 0 {
+			not_zero = true
+		}
+	}
+	if state.IntervalStart == self.interval_start {
+		for oid, v := range counters {
+			self.counters[oid] += v
+		}
+		for oid, h := range histograms {
+			self.histograms[oid].merge(h)
+		}
+		Info("KpiFile::Restore counters of current interval [%s] from [%s]", time.Unix(state.IntervalStart, 0).Format("20060102150405"), path)
+		self.updated = true
+		self.dirty = true
+		return self.checkpoint()
+	}
+	begin := state.IntervalStart
+	if state.FlushedEnd > begin {
+		begin = state.FlushedEnd //e.g. a partial interval flushed on shutdown
+	}
+	end := state.IntervalStart + g_log_cfg.KpiInterval
+	if not_zero && state.IntervalStart < self.interval_start && end > state.FlushedEnd {
+		//fill the configured oids missing in the state with 0
+		for oid, _ := range self.counters {
+			counters[oid] += 0
+		}
+		for oid, current := range self.histograms {
+			h, present := histograms[oid]
+			if !present {
+				h = current
+			}
+			h.rows(oid, g_log_cfg.GetKpiBuckets(oid), counters)
+		}
+		Info("KpiFile::Restore write counters of stale interval [%s]", time.Unix(state.IntervalStart, 0).Format("20060102150405"))
+		err = writeKpiFile(counters, time.Unix(begin, 0), time.Unix(end, 0))
+		if err != nil {
+			return err
+		}
+	}
+	if state.IntervalStart < self.interval_start {
+		//the updown and gauge kpis carry their values over to the current interval
+		for oid, v := range counters {
+			if g_log_cfg.GetKpiKind(oid) != KPI_COUNTER {
+				self.counters[oid] = v
+			}
+		}
+	}
+	return self.checkpoint()
+}
@@ -0,0 +1,138 @@
+package applog
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// load a config with memory transport under a temp dir, the default Logger is reset at the end of the test,
+// edit changes the config before it is saved
+func loadTestCfg(t testing.TB, mq_id int64, edit ...func(cfg *LogCfg)) *LogCfg {
+	t.Cleanup(ResetLog)
+	dir := t.TempDir()
+	cfg := &LogCfg{
+		MQID:         mq_id,
+		Transport:    TRANSPORT_MEMORY,
+		LogPath:      dir,
+		AlarmKpiPath: dir,
+		KpiInterval:  300,
+		KpiOid:       map[string]string{"REQ_COUNT": "1.3.1.2.1", "RES_COUNT": "1.3.1.2.3"},
+	}
+	for _, f := range edit {
+		f(cfg)
+	}
+	file := filepath.Join(dir, "test.cfg")
+	err := cfg.Save(file)
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	err = LoadLogCfg(file)
+	if err != nil {
+		t.Fatalf("LoadLogCfg: %v", err)
+	}
+	return Config()
+}
+
+func TestKpiRestore(t *testing.T) {
+	cfg := loadTestCfg(t, 7895)
+	t.Cleanup(func() { SetClock(nil) })
+	SetClock(fixedClock(time.Date(2016, 5, 18, 10, 1, 0, 0, time.Local)))
+	kf, err := NewKpiFile()
+	if err != nil {
+		t.Fatalf("NewKpiFile: %v", err)
+	}
+	WriteKpi("REQ_COUNT", 5)
+	kf.Process()
+
+	restarted, _ := NewKpiFile()
+	err = restarted.Restore()
+	if err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if restarted.interval_start != kf.interval_start || restarted.Counters()["1.3.1.2.1"] != 5 {
+		t.Fatalf("counters of current interval not restored: %v", restarted.Counters())
+	}
+
+	//checkpoint of a stale interval is written as its own KPI file
+	stale := kf.interval_start - 2*cfg.KpiInterval
+	b, _ := json.Marshal(&kpiState{IntervalStart: stale, Counters: map[string]int64{"1.3.1.2.1": 7}})
+	os.WriteFile(filepath.Join(cfg.AlarmKpiPath, KPI_STATE_FILE), b, 0644)
+	restarted, _ = NewKpiFile()
+	err = restarted.Restore()
+	if err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if restarted.Counters()["1.3.1.2.1"] != 0 {
+		t.Fatalf("counters of stale interval shall not be restored: %v", restarted.Counters())
+	}
+	end := time.Unix(stale+cfg.KpiInterval, 0)
+	filename, _ := GenerateFileNameAt("KPI", end)
+	content, err := os.ReadFile(filepath.Join(cfg.AlarmKpiPath, filename))
+	if err != nil {
+		t.Fatalf("KPI file of stale interval not written: %v", err)
+	}
+	ts := end.Format("20060102150405")
+	expect := ts + "|kpi_collector|KPI|1.3.1.2.1|7\n" + ts + "|kpi_collector|KPI|1.3.1.2.3|0\n"
+	if string(content) != expect {
+		t.Fatalf("expect [%s] but [%s]", expect, string(content))
+	}
+}
+
+func TestKpiFlushCrash(t *testing.T) {
+	cfg := loadTestCfg(t, 7910)
+	t.Cleanup(func() { SetClock(nil) })
+	SetClock(fixedClock(time.Date(2016, 5, 18, 10, 1, 0, 0, time.Local)))
+	kf, err := NewKpiFile()
+	if err != nil {
+		t.Fatalf("NewKpiFile: %v", err)
+	}
+	WriteKpi("REQ_COUNT", 5)
+	kf.Process()
+	SetClock(fixedClock(time.Date(2016, 5, 18, 10, 5, 30, 0, time.Local)))
+	err = kf.Flush()
+	if err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	//crash after the checkpoint and before the tmp file is published
+	filename, _ := GenerateFileNameAt("KPI", time.Date(2016, 5, 18, 10, 5, 0, 0, time.Local))
+	os.Rename(filepath.Join(cfg.AlarmKpiPath, filename), filepath.Join(cfg.AlarmKpiPath, ".kpi.tmp"))
+	_, err = RecoverTmpFiles()
+	if err != nil {
+		t.Fatalf("RecoverTmpFiles: %v", err)
+	}
+	restarted, _ := NewKpiFile()
+	err = restarted.Restore()
+	if err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if restarted.Counters()["1.3.1.2.1"] != 0 {
+		t.Fatalf("flushed counters restored: %v", restarted.Counters())
+	}
+
+	//a checkpoint of the interval already flushed is not written again
+	start := time.Date(2016, 5, 18, 10, 0, 0, 0, time.Local).Unix()
+	b, _ := json.Marshal(&kpiState{IntervalStart: start, FlushedEnd: start + cfg.KpiInterval, Counters: map[string]int64{"1.3.1.2.1": 5}})
+	os.WriteFile(filepath.Join(cfg.AlarmKpiPath, KPI_STATE_FILE), b, 0644)
+	restarted, _ = NewKpiFile()
+	err = restarted.Restore()
+	if err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+
+	entries, _ := os.ReadDir(cfg.AlarmKpiPath)
+	written := 0
+	for _, e := range entries {
+		content, _ := os.ReadFile(filepath.Join(cfg.AlarmKpiPath, e.Name()))
+		if strings.Contains(string(content), "|1.3.1.2.1|5\n") {
+			written++
+		}
+	}
+	if written != 1 {
+		t.Fatalf("interval written %d times", written)
+	}
+}
@@ -3,9 +3,8 @@ package applog
 import (
 	"errors"
 	"fmt"
-	"os"
 	"sync"
-	"sysvipc"
+	"sync/atomic"
 	"time"
 )
 
@@ -43,20 +42,87 @@ func Level2Str(l LOG_LEVEL) string {
 	}
 }
 
+/*Parse the level string, return MAX_LEVEL if undefined*/
+func Str2Level(s string) LOG_LEVEL {
+	for l := DEBUG; l < MAX_LEVEL; l++ {
+		if Level2Str(l) == s {
+			return l
+		}
+	}
+	return MAX_LEVEL
+}
+
 type Logger struct {
-	LogPath     string
-	LogFilename string
-	LogFullpath string
-	AppName     string
-	mutex       sync.Mutex
+	LogPath      string
+	LogFilename  string
+	LogFullpath  string
+	AppName      string
+	cfg          *LogCfg
+	transport    Transport
+	own_trans    bool        //transport is created by NewLogger and shall be closed with the Logger
+	debug        atomic.Bool //read without the mutex, so that the async writers are not blocked by the I/O
+	stdout       bool
+	available    bool
+	file         *RotatingFile
+	flush_intv   time.Duration
+	rotate       *RotatePolicy
+	stop_flush   chan struct{}
+	queue        atomic.Pointer[asyncQueue]
+	async_size   int
+	async_policy OverflowPolicy
+	dropped_base atomic.Uint64 //dropped records of the closed async queues
+	kpi_window   time.Duration
+	kpi_pending  map[string]int64 //kpi oid to the delta accumulated in the batch window
+	stop_kpi     chan struct{}
+	mutex        sync.Mutex
+}
+
+type LoggerOption func(*Logger)
+
+/*write log to the file under log_path of the config, "mq" or "MQ" to write log to the transport*/
+func WithLogFile(filename string) LoggerOption {
+	return func(l *Logger) {
+		l.LogFilename = filename
+	}
+}
+
+func WithDebug(d bool) LoggerOption {
+	return func(l *Logger) {
+		l.debug.Store(d)
+	}
+}
+
+func WithStdout(s bool) LoggerOption {
+	return func(l *Logger) {
+		l.stdout = s
+	}
+}
+
+/*interval to flush the buffered log file, DEFAULT_FLUSH_INTERVAL by default*/
+func WithFlushInterval(d time.Duration) LoggerOption {
+	return func(l *Logger) {
+		l.flush_intv = d
+	}
+}
+
+/*rotation policy of the log file, the log_rotate item of the config by default*/
+func WithRotation(policy RotatePolicy) LoggerOption {
+	return func(l *Logger) {
+		l.rotate = &policy
+	}
 }
 
+/*share the transport instead of creating a new one from the config*/
+func WithTransport(tr Transport) LoggerOption {
+	return func(l *Logger) {
+		l.transport = tr
+	}
+}
+
+/*the default Logger used by the package level functions*/
 var g_logger Logger
-var g_debug_flag = false
-var g_stdout_flag = false
 var g_log_cfg *LogCfg
-var g_mq sysvipc.MessageQueue
-var g_log_available = false
+var g_transport Transport
 
 func Config() *LogCfg {
 	return g_log_cfg
@@ -67,30 +133,102 @@ func Log() *Logger {
 }
 
 func DumpLog() string {
-	return fmt.Sprintf("LogPath: %s\nLogFilename: %s\nLogFullpath: %s\nAppName: %s\n", g_logger.LogPath, g_logger.LogFilename, g_logger.LogFullpath, g_logger.AppName)
+	return g_logger.Dump()
+}
+
+func isMQ(filename string) bool {
+	return filename == "mq" || filename == "MQ"
+}
+
+// Create a Logger with its own config, app name and sinks.
+// With nil cfg, the Logger works in simple mode, only write log to stdout.
+// Without WithLogFile option, the Logger write log to stdout, and KPI/Alarm to the transport
+func NewLogger(cfg *LogCfg, app_name string, opts ...LoggerOption) (*Logger, error) {
+	if len(app_name) < 1 {
+		return nil, errors.New("NewLogger: empty app_name")
+	}
+	if cfg != nil {
+		err := cfg.buildKpiKinds()
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("NewLogger: %v", err))
+		}
+	}
+	l := &Logger{cfg: cfg}
+	for _, opt := range opts {
+		opt(l)
+	}
+	if cfg != nil && l.transport == nil {
+		tr, err := NewTransport(cfg)
+		if err != nil {
+			return nil, err
+		}
+		l.transport = tr
+		l.own_trans = true
+	}
+	var err error
+	l.mutex.Lock()
+	if len(l.LogFilename) > 0 {
+		err = l.init(l.LogFilename, app_name)
+	} else {
+		//write log to stdout, the KPI/alarms still go to the transport with a config
+		l.AppName = app_name
+		l.stdout = true
+		l.available = cfg != nil
+	}
+	l.mutex.Unlock()
+	if err == nil {
+		err = l.startAsync()
+	}
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
 }
 
 func InitLog(filename string, app_name string) error {
+	g_logger.stopAsync()
 	g_logger.mutex.Lock()
-	defer g_logger.mutex.Unlock()
+	err := g_logger.init(filename, app_name)
+	g_logger.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	return g_logger.startAsync()
+}
 
-	if len(g_log_cfg.LogPath) < 1 || len(filename) < 1 || len(app_name) < 1 {
-		return errors.New(fmt.Sprintf("InitLog: invalid arguments path[%s] filename[%s] app_name[%s]", g_log_cfg.LogPath, filename, app_name))
+func (self *Logger) init(filename string, app_name string) error {
+	if self.cfg == nil {
+		return errors.New(fmt.Sprintf("InitLog: config not loaded, filename[%s] app_name[%s]", filename, app_name))
+	}
+	if len(self.cfg.LogPath) < 1 || len(filename) < 1 || len(app_name) < 1 {
+		return errors.New(fmt.Sprintf("InitLog: invalid arguments path[%s] filename[%s] app_name[%s]", self.cfg.LogPath, filename, app_name))
 	}
+	self.closeFile()
 	/* if filename == "mq"||"MQ“ do not write to file */
-	if filename != "mq" || filename != "MQ" {
-		err := ValidateFile(g_log_cfg.LogPath + "/" + filename)
+	if !isMQ(filename) {
+		policy := self.rotate
+		if policy == nil {
+			policy = &self.cfg.LogRotate
+		}
+		lf, err := NewRotatingFile(self.cfg.LogPath+"/"+filename, *policy)
 		if err != nil {
-			return errors.New(fmt.Sprintf("InitLog [%s/%s] failed, %v", g_log_cfg.LogPath, filename, err))
+			return errors.New(fmt.Sprintf("InitLog [%s/%s] failed, %v", self.cfg.LogPath, filename, err))
+		}
+		self.file = lf
+		if self.flush_intv <= 0 {
+			self.flush_intv = DEFAULT_FLUSH_INTERVAL
 		}
-		g_logger.LogPath = g_log_cfg.LogPath
+		self.stop_flush = make(chan struct{})
+		go self.flushRoutine(self.stop_flush, self.flush_intv)
+		self.LogPath = self.cfg.LogPath
 	} else {
-		g_logger.LogPath = "mq"
+		self.LogPath = "mq"
 	}
-	g_logger.LogFilename = filename
-	g_logger.LogFullpath = g_log_cfg.LogPath + "/" + filename
-	g_logger.AppName = app_name
-	g_log_available = true
+	self.LogFilename = filename
+	self.LogFullpath = self.cfg.LogPath + "/" + filename
+	self.AppName = app_name
+	self.available = true
 	return nil
 }
 
@@ -100,98 +238,347 @@ func LoadLogCfg(config_file string) error {
 	if err != nil {
 		return err
 	}
-	mq, err := sysvipc.GetMsgQueue(cfg.MQID, &sysvipc.MQFlags{true, false, 0660})
+	tr, err := NewTransport(&cfg)
 	if err != nil {
 		return err
 	}
+	g_logger.mutex.Lock()
+	g_logger.flushKpi() //the accumulated deltas go with the old transport
+	if g_transport != nil {
+		g_transport.Close()
+	}
 	g_log_cfg = &cfg
-	g_mq = mq
-
+	g_transport = tr
+	g_logger.cfg = g_log_cfg
+	g_logger.transport = g_transport
+	g_logger.mutex.Unlock()
 	return nil
 }
 
+/*Close the default Logger and the transport, and unload the config, e.g. between tests*/
+func ResetLog() {
+	CloseLog()
+	g_logger.mutex.Lock()
+	defer g_logger.mutex.Unlock()
+	if g_transport != nil {
+		g_transport.Close()
+	}
+	g_log_cfg = nil
+	g_transport = nil
+	g_logger.cfg = nil
+	g_logger.transport = nil
+	g_logger.stdout = false
+	g_logger.debug.Store(false)
+}
+
 func DebugLog(d bool) {
-	g_debug_flag = d
+	g_logger.SetDebug(d)
 }
 
 func StdoutLog(s bool) {
-	g_stdout_flag = s
+	g_logger.SetStdout(s)
 }
 
 func IncreaseKpi(kpi_name string) error {
-	return WriteKpi(kpi_name, 1)
+	return g_logger.IncreaseKpi(kpi_name)
 }
 
 func DecreaseKpi(kpi_name string) error {
-	return WriteKpi(kpi_name, 1)
+	return g_logger.DecreaseKpi(kpi_name)
 }
 
 func WriteKpi(kpi_name string, delta int64) error {
-	g_logger.mutex.Lock()
-	defer g_logger.mutex.Unlock()
-	if g_log_cfg == nil {
+	return g_logger.WriteKpi(kpi_name, delta)
+}
+
+func WriteKpiLabels(kpi_name string, delta int64, labels map[string]string) error {
+	return g_logger.WriteKpiLabels(kpi_name, delta, labels)
+}
+
+func SetKpi(kpi_name string, value int64) error {
+	return g_logger.SetKpi(kpi_name, value)
+}
+
+func ObserveKpi(kpi_name string, value int64) error {
+	return g_logger.ObserveKpi(kpi_name, value)
+}
+
+func Db(format string, v ...interface{}) {
+	g_logger.WriteLog(DEBUG, "", format, v...)
+}
+
+func Info(format string, v ...interface{}) {
+	g_logger.WriteLog(INFO, "", format, v...)
+}
+
+func WriteLog(level LOG_LEVEL, alarm_name string, format string, v ...interface{}) {
+	g_logger.WriteLog(level, alarm_name, format, v...)
+}
+
+/*Flush the log file of the default Logger*/
+func FlushLog() error {
+	return g_logger.Flush()
+}
+
+/*Flush and close the log file of the default Logger, shall be called before the application exits*/
+func CloseLog() error {
+	return g_logger.Close()
+}
+
+func WriteLogKV(level LOG_LEVEL, alarm_name string, msg string, kv ...interface{}) {
+	g_logger.WriteLogKV(level, alarm_name, msg, kv...)
+}
+
+func (self *Logger) Config() *LogCfg {
+	return self.cfg
+}
+
+func (self *Logger) Dump() string {
+	return fmt.Sprintf("LogPath: %s\nLogFilename: %s\nLogFullpath: %s\nAppName: %s\n", self.LogPath, self.LogFilename, self.LogFullpath, self.AppName)
+}
+
+func (self *Logger) SetDebug(d bool) {
+	self.debug.Store(d)
+}
+
+func (self *Logger) SetStdout(s bool) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.stdout = s
+}
+
+/*Flush the buffered log file*/
+func (self *Logger) Flush() error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.file == nil {
+		return nil
+	}
+	return self.file.Flush()
+}
+
+/*flush the log file per interval, and reopen it if it has been renamed away*/
+func (self *Logger) flushRoutine(stop chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			self.mutex.Lock()
+			if self.file != nil {
+				self.file.RotateIfDue()
+				self.file.Flush()
+				self.file.ReopenIfMoved()
+			}
+			self.mutex.Unlock()
+		}
+	}
+}
+
+func (self *Logger) closeFile() {
+	if self.stop_flush != nil {
+		close(self.stop_flush)
+		self.stop_flush = nil
+	}
+	if self.file != nil {
+		self.file.Close()
+		self.file = nil
+	}
+}
+
+/*Flush and close the log file, close the transport created by NewLogger, the Logger shall not be used after Close*/
+func (self *Logger) Close() error {
+	self.stopAsync()
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.closeFile()
+	self.closeKpiBatch()
+	self.available = false
+	if self.own_trans && self.transport != nil {
+		err := self.transport.Close()
+		self.transport = nil
+		return err
+	}
+	return nil
+}
+
+func (self *Logger) IncreaseKpi(kpi_name string) error {
+	return self.WriteKpi(kpi_name, 1)
+}
+
+func (self *Logger) DecreaseKpi(kpi_name string) error {
+	return self.WriteKpi(kpi_name, -1)
+}
+
+func (self *Logger) WriteKpi(kpi_name string, delta int64) error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.sendKpi(kpi_name, delta)
+}
+
+/*Add the delta to the kpi of the label combination, e.g. {"peer": "hss01", "result": "2001"}*/
+func (self *Logger) WriteKpiLabels(kpi_name string, delta int64, labels map[string]string) error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.cfg == nil || self.transport == nil {
+		return errors.New("WriteKpiLabels failed, mq not initialized")
+	}
+	oid, err := self.cfg.GetKpiLabelOid(kpi_name, labels)
+	if err != nil {
+		return errors.New(fmt.Sprintf("WriteKpiLabels failed: %v", err))
+	}
+	self.sendDelta(oid, delta)
+	return nil
+}
+
+/*Set the value of a gauge or updown kpi*/
+func (self *Logger) SetKpi(kpi_name string, value int64) error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.cfg == nil || self.transport == nil {
+		return errors.New("SetKpi failed, mq not initialized")
+	}
+	oid, err := self.cfg.GetKpiOid(kpi_name)
+	if err != nil {
+		return errors.New("SetKpi failed, invalid kpi_name " + kpi_name)
+	}
+	if self.cfg.GetKpiKind(oid) == KPI_COUNTER {
+		return errors.New("SetKpi failed, kpi_name " + kpi_name + " is a counter")
+	}
+	self.flushKpi(oid) //the deltas before shall be applied before the value is set
+	kpi_line := fmt.Sprintf("%s|%s%d", oid, KPI_SET_PREFIX, value)
+	self.transport.Send(KPI_MSG_TYPE, []byte(kpi_line), true)
+	return nil
+}
+
+/*Observe a value, e.g. a latency, of a histogram kpi*/
+func (self *Logger) ObserveKpi(kpi_name string, value int64) error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.cfg == nil || self.transport == nil {
+		return errors.New("ObserveKpi failed, mq not initialized")
+	}
+	oid, err := self.cfg.GetKpiOid(kpi_name)
+	if err != nil {
+		return errors.New("ObserveKpi failed, invalid kpi_name " + kpi_name)
+	}
+	if self.cfg.GetKpiKind(oid) != KPI_HISTOGRAM {
+		return errors.New("ObserveKpi failed, kpi_name " + kpi_name + " is not a histogram")
+	}
+	kpi_line := fmt.Sprintf("%s|%s%d", oid, KPI_OBSERVE_PREFIX, value)
+	self.transport.Send(KPI_MSG_TYPE, []byte(kpi_line), true)
+	return nil
+}
+
+/*send the kpi record, the mutex shall be held by the caller*/
+func (self *Logger) sendKpi(kpi_name string, delta int64) error {
+	if self.cfg == nil || self.transport == nil {
 		return errors.New("WriteKpi failed, mq not initialized")
 	}
-	oid, err := g_log_cfg.GetKpiOid(kpi_name)
+	oid, err := self.cfg.GetKpiOid(kpi_name)
 	if err != nil {
 		return errors.New("WriteKpi failed, invalid kpi_name " + kpi_name)
 	}
-	kpi_line := fmt.Sprintf("%s|%d", oid, delta)
-	//fmt.Printf("WriteKpi [%s][%v]\n", kpi_line, []byte(kpi_line))
-	g_mq.Send(KPI_MSG_TYPE, []byte(kpi_line), &sysvipc.MQSendFlags{true})
+	self.sendDelta(oid, delta)
 	return nil
 }
 
-func Db(format string, v ...interface{}) {
-	WriteLog(DEBUG, "", format, v...)
+func (self *Logger) Db(format string, v ...interface{}) {
+	self.WriteLog(DEBUG, "", format, v...)
 }
 
-func Info(format string, v ...interface{}) {
-	WriteLog(INFO, "", format, v...)
+func (self *Logger) Info(format string, v ...interface{}) {
+	self.WriteLog(INFO, "", format, v...)
 }
 
-func WriteLog(level LOG_LEVEL, alarm_name string, format string, v ...interface{}) {
-	g_logger.mutex.Lock()
-	defer g_logger.mutex.Unlock()
+func (self *Logger) WriteLog(level LOG_LEVEL, alarm_name string, format string, v ...interface{}) {
+	self.write(level, "", alarm_name, fmt.Sprintf(format, v...), nil)
+}
 
-	ts := time.Now().Format("20060102-150405.000")
-	if !g_debug_flag && level == DEBUG {
+/*Write log with structured key/value fields, e.g. WriteLogKV(INFO, NO_ALARM, "request done", "user", id, "latency_ms", 12)*/
+func (self *Logger) WriteLogKV(level LOG_LEVEL, alarm_name string, msg string, kv ...interface{}) {
+	self.write(level, "", alarm_name, msg, KV2Fields(kv...))
+}
+
+/*write the log record, the app label overrides the AppName of the Logger if not empty*/
+func (self *Logger) write(level LOG_LEVEL, app string, alarm_name string, msg string, fields []Field) {
+	if !self.debug.Load() && level == DEBUG {
 		return
 	}
-	line := ts + "|" + g_logger.AppName + "|" + Level2Str(level) + "|" + fmt.Sprintf(format, v...)
-	if g_stdout_flag || !g_log_available { //if no log file available print to stdout
+	e := logEntry{
+		level: level,
+		alarm: alarm_name,
+		rec: LogRecord{
+			Timestamp: Now().Format("20060102-150405.000"),
+			App:       app,
+			Level:     Level2Str(level),
+			Msg:       msg,
+			Fields:    fields,
+		},
+	}
+	self.dispatch(e)
+}
+
+/*queue the log entry if async, otherwise write it to the sinks*/
+func (self *Logger) dispatch(e logEntry) {
+	q := self.queue.Load()
+	if q != nil && q.push(e) {
+		return
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.emit(&e)
+}
+
+/*write the log entry to the sinks, the mutex shall be held by the caller*/
+func (self *Logger) emit(e *logEntry) {
+	if len(e.rec.App) < 1 {
+		e.rec.App = self.AppName
+	}
+	line := e.rec.String()
+	if self.stdout || !self.available { //if no log file available print to stdout
 		fmt.Println(line)
 	}
-	if !g_log_available { //simple mode, only write to stdout
+	if !self.available { //simple mode, only write to stdout
 		return
 	}
 
-	if g_logger.LogFilename == "mq" {
-		err := g_mq.Send(LOG_MSG_TYPE, []byte(line+"\n"), &sysvipc.MQSendFlags{true})
+	if isMQ(self.LogFilename) {
+		err := self.transport.Send(LOG_MSG_TYPE, []byte(line+"\n"), true)
 		if err != nil {
 			fmt.Printf("log failed to write to mq: %s\n", line)
 			return
 		}
-	} else {
-		f, err := os.OpenFile(g_logger.LogFullpath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	} else if self.file != nil {
+		if e.level >= ERROR {
+			self.file.ReopenIfMoved() //do not wait for the flush tick to notice a moved file
+		}
+		_, err := self.file.Write([]byte(line + "\n"))
 		if err != nil {
-			//fmt.Printf("WriteLog [%s] failed\n", g_logger.LogFullpath)
+			//fmt.Printf("WriteLog [%s] failed\n", self.LogFullpath)
 			fmt.Println(err)
 			return
 		}
-		defer f.Close()
-		f.Write([]byte(line + "\n"))
+		if e.level >= ERROR {
+			self.file.Flush()
+		}
 	}
 
 	////////////// write alarm string to mq ///////////////
 	/*20160514030208|DC:AOC_001:C001|ERROR|.1.3.6.1.4.1.193.176.3.4.2|Cannot connect to backup DCC server. ip address: , port: 0. Invalid ip address or port. The current instance of dcc_client is DC:AOC_001:C001*/
-	if g_log_cfg != nil && level > INFO && level < MAX_LEVEL {
-		oid, err := g_log_cfg.GetAlarmOid(alarm_name)
+	if self.cfg != nil && self.transport != nil && e.level > INFO && e.level < MAX_LEVEL {
+		oid, err := self.cfg.GetAlarmOid(e.alarm)
 		if err != nil || oid == NO_ALARM {
 			return
 		}
-		alarm_line := fmt.Sprintf("%s|%s|%s|.%s|%s", time.Now().Format("20060102150405"), g_logger.AppName, Level2Str(level), oid, fmt.Sprintf(format, v...))
+		level := e.rec.Level
+		if e.stateful {
+			level += ALARM_STATE_FLAG
+		}
+		alarm_line := fmt.Sprintf("%s|%s|%s|.%s|%s", Now().Format("20060102150405"), e.rec.App, level, oid, e.rec.Content())
 		//fmt.Printf("WriteAlarm [%s][%v]\n", alarm_line, []byte(alarm_line))
-		g_mq.Send(ALARM_MSG_TYPE, []byte(alarm_line), &sysvipc.MQSendFlags{true})
+		self.transport.Send(ALARM_MSG_TYPE, []byte(alarm_line), true)
 	}
 }
@@ -4,64 +4,194 @@ import (
 	log "applog"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"sync"
+	"syscall"
 	"time"
 )
 
-func LogRoutine(wg *sync.WaitGroup, filename string) {
+/*sleep for the duration, return true if stop is signaled*/
+func sleepOrStop(stop <-chan struct{}, d time.Duration) bool {
+	select {
+	case <-stop:
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
+
+func LogRoutine(wg *sync.WaitGroup, stop <-chan struct{}, router *log.LogRouter) {
 	defer wg.Done()
-	last_day := time.Now().Format("20060102")
-	var f *os.File
+	defer router.Close()
 	rec_per_cycle := 50
 	for {
-		if f == nil {
-			f, _ = os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
-		}
-		//main loop to write log from MQ to file
-		err := log.ProcLogRecCycle(f, rec_per_cycle)
+		//main loop to write log from MQ to the routed files, each file switches per day
+		n, err := log.ProcLogRecNowait(router, rec_per_cycle)
 		if err != nil {
 			fmt.Println("LogRoutine err:", err)
-			time.Sleep(1000 * time.Millisecond)
-		}
-		//check if day pass then trigger a file swtich
-		now_day := time.Now().Format("20060102")
-		if last_day != now_day {
-			if f != nil {
-				f.Close()
+			if sleepOrStop(stop, 1000*time.Millisecond) {
+				return
 			}
-			os.Rename(filename, filename+"."+last_day)
-			last_day = now_day
+		}
+		router.Flush()
+		if n < rec_per_cycle && sleepOrStop(stop, 100*time.Millisecond) {
+			drainLog(router, rec_per_cycle)
+			return
+		}
+	}
+}
+
+/*write the remaining log records to the router before exit*/
+func drainLog(router *log.LogRouter, rec_per_cycle int) {
+	for {
+		n, err := log.ProcLogRecNowait(router, rec_per_cycle)
+		if err != nil || n == 0 {
+			return
+		}
+	}
+}
+
+/*compress and remove the rotated log files on startup, on rotation and per hour*/
+func HousekeepRoutine(wg *sync.WaitGroup, stop <-chan struct{}, paths []string, policy log.HousekeepPolicy, rotated <-chan struct{}) {
+	defer wg.Done()
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		for _, path := range paths {
+			log.Housekeep(path, policy)
+		}
+		select {
+		case <-rotated:
+		case <-ticker.C:
+		case <-stop:
+			return
 		}
 	}
 }
 
-func KpiRoutine(wg *sync.WaitGroup, kc *log.KpiFile) {
+/*drain the kpi records per 100ms and flush the KPI file once the interval boundary of the applog clock is passed,
+the boundary is polled instead of timed, so a fake clock drives the flush as well and a late tick gets the missed intervals*/
+func KpiRoutine(wg *sync.WaitGroup, stop <-chan struct{}, kc *log.KpiFile) {
 	defer wg.Done()
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
 	for {
-		kc.Process()
-		kc.Flush()
-		time.Sleep(100 * time.Millisecond)
+		select {
+		case <-ticker.C:
+			kc.Process()
+			err := kc.Flush()
+			if err != nil {
+				log.WriteLog(log.ERROR, "KPI_FLUSH_FAIL", "Failed to flush KPI file: %v", err)
+			}
+		case <-stop:
+			drainKpi(kc)
+			return
+		}
+	}
+}
 
+/*process the remaining kpi records and flush the partial interval before exit*/
+func drainKpi(kc *log.KpiFile) {
+	kc.Process()
+	kc.Flush() //the boundary may be passed while stopping
+	err := kc.ForceFlush()
+	if err != nil {
+		log.WriteLog(log.ERROR, "APP_STOP", "Failed to flush KPI file on exit: %v", err)
 	}
 }
 
-func AlarmRoutine(wg *sync.WaitGroup, af *log.AlarmFile) {
+/*drain the alarm records per 500ms and commit the WARNING file once the interval boundary of the applog clock is passed*/
+func AlarmRoutine(wg *sync.WaitGroup, stop <-chan struct{}, af *log.AlarmFile) {
 	defer wg.Done()
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
 	for {
-		af.Process()
-		af.Flush()
-		time.Sleep(500 * time.Millisecond)
+		select {
+		case <-ticker.C:
+			af.Process()
+			err := af.Flush()
+			if err != nil {
+				log.WriteLog(log.ERROR, "ALARM_FLUSH_FAIL", "Failed to write WARNING file: %v", err)
+			}
+		case <-stop:
+			drainAlarm(af)
+			return
+		}
+	}
+}
+
+/*process the remaining alarm records and commit the WARNING file before exit*/
+func drainAlarm(af *log.AlarmFile) {
+	af.Process()
+	err := af.ForceFlush()
+	if err != nil {
+		log.WriteLog(log.ERROR, "APP_STOP", "Failed to flush WARNING file on exit: %v", err)
 	}
 }
 
+/*close stop on the first signal, a second one exits at once in case the drain hangs*/
+func handleSignals(sig <-chan os.Signal, stop chan<- struct{}, exit func(int)) {
+	s := <-sig
+	log.WriteLog(log.INFO, "", "Receive signal [%v], stopping ...", s)
+	close(stop)
+	s = <-sig
+	log.WriteLog(log.WARN, log.NO_ALARM, "Receive signal [%v] again, exit without draining", s)
+	exit(1)
+}
+
+/*wait for the other routines to drain, then stop the LogRoutine to write the records they logged on shutdown*/
+func shutdown(wg *sync.WaitGroup, log_stop chan<- struct{}, log_wg *sync.WaitGroup) {
+	wg.Wait()
+	log.WriteLog(log.INFO, "", "Exit ...")
+	close(log_stop)
+	log_wg.Wait()
+}
+
+/*serve the kpi and alarm metrics on addr until stop is signaled*/
+func MetricsRoutine(wg *sync.WaitGroup, stop <-chan struct{}, addr string, kc *log.KpiFile, af *log.AlarmFile) {
+	defer wg.Done()
+	mux := http.NewServeMux()
+	mux.Handle(log.METRICS_PATH, log.NewMetricsHandler(kc, af))
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		<-stop
+		server.Close()
+	}()
+	err := server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		log.WriteLog(log.ERROR, "METRICS_FAIL", "Failed to serve metrics on [%s]: %v", addr, err)
+	}
+}
+
+/*print the active alarms one per line, return the exit code*/
+func listActiveAlarms() int {
+	alarms, err := log.LoadActiveAlarms()
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	for _, a := range alarms {
+		fmt.Printf("%s|%s|%s|.%s|%s|%d|%s\n", a.FirstRaised.Format("20060102150405"), a.App, a.Level, a.Oid, a.Resource, a.Count, a.Content)
+	}
+	return 0
+}
+
 func main() {
 	pcfg := flag.String("c", "", "the alarm & kpi config file in json format")
 	global_logfile := flag.String("g_log", "app.log", "the global log filename")
 	debug := flag.Bool("d", false, "if turn on debug log")
 	stdout := flag.Bool("p", false, "if print log to stdout")
+	log_format := flag.String("log_format", log.FORMAT_PIPE, "the global log file format, pipe or json")
+	metrics_addr := flag.String("metrics", "", "the address to serve the prometheus /metrics endpoint, e.g. :9100, disabled if empty")
+	list_alarms := flag.Bool("active_alarms", false, "list the active alarms saved by the running or last log_aggregator and exit")
 	flag.Parse()
+	if *log_format != log.FORMAT_PIPE && *log_format != log.FORMAT_JSON {
+		fmt.Printf("Unknown log format [%s], it shall be %s or %s\n", *log_format, log.FORMAT_PIPE, log.FORMAT_JSON)
+		os.Exit(1)
+	}
 	cfg := os.Getenv("APP_LOG_CFG")
 	if len(*pcfg) > 0 { //the argument shall override the env
 		cfg = *pcfg
@@ -75,6 +205,9 @@ func main() {
 		fmt.Println("Load LogCfg", cfg, " failed", err)
 		os.Exit(1)
 	}
+	if *list_alarms {
+		os.Exit(listActiveAlarms())
+	}
 	log.DebugLog(*debug)
 	log.StdoutLog(*stdout)
 	err = log.InitLog("alarm_kpi_aggregator.log", "AGGREGATOR")
@@ -82,6 +215,7 @@ func main() {
 		fmt.Println("fail to InitLog:", log.Config().Dump())
 		os.Exit(1)
 	}
+	log.Info("Load LogCfg [%s]\n%s", cfg, log.Config().Dump())
 
 	fullpath := filepath.Join(log.Config().LogPath, *global_logfile)
 	err = log.ValidateFile(fullpath)
@@ -90,22 +224,68 @@ func main() {
 		os.Exit(1)
 	}
 
+	router, err := log.NewLogRouter(log.Config().LogPath, *global_logfile, log.Config().LogRoutes,
+		log.RotatePolicy{Period: log.ROTATE_DAY}, *log_format == log.FORMAT_JSON)
+	if err != nil {
+		log.WriteLog(log.ERROR, "APP_START", "Failed to open routed log files: %v. Exit", err)
+		os.Exit(1)
+	}
+	rotated := make(chan struct{}, 1)
+	router.SetRotateHook(func(path string) {
+		log.Info("Switch log file to [%s]", path)
+		select {
+		case rotated <- struct{}{}:
+		default: //housekeeping already triggered
+		}
+	})
+
+	actions, err := log.RecoverTmpFiles()
+	if err != nil {
+		log.WriteLog(log.ERROR, "APP_START", "Failed to recover tmp files: %v", err)
+	} else if len(actions) > 0 {
+		log.WriteLog(log.WARN, log.NO_ALARM, "Recovered %d tmp files left by last run", len(actions))
+	}
+
 	kpiCounter, err := log.NewKpiFile()
 	if err != nil {
 		log.WriteLog(log.ERROR, "APP_START", "Failed to NewKpiFile: %v. Exit", err)
 		os.Exit(1)
 	}
+	err = kpiCounter.Restore()
+	if err != nil {
+		log.WriteLog(log.ERROR, "APP_START", "Failed to restore KPI counters: %v", err)
+	}
 	alarmFile := &log.AlarmFile{}
+	err = alarmFile.Restore()
+	if err != nil {
+		log.WriteLog(log.ERROR, "APP_START", "Failed to restore active alarms: %v", err)
+	}
+
+	stop := make(chan struct{})
+	sig := make(chan os.Signal, 2)
+	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
+	go handleSignals(sig, stop, os.Exit)
 
 	wg := &sync.WaitGroup{}
 	wg.Add(3)
+	//LogRoutine stops after the others, so it drains the records logged on their shutdown
+	log_stop := make(chan struct{})
+	log_wg := &sync.WaitGroup{}
+	log_wg.Add(1)
 	log.WriteLog(log.INFO, "", "Launch LogRoutine")
-	go LogRoutine(wg, fullpath)
+	go LogRoutine(log_wg, log_stop, router)
+	log.WriteLog(log.INFO, "", "Launch HousekeepRoutine")
+	go HousekeepRoutine(wg, stop, router.Paths(), log.Config().AppLogKeep, rotated)
 	log.WriteLog(log.INFO, "", "Launch AlarmRoutine")
-	go AlarmRoutine(wg, alarmFile)
+	go AlarmRoutine(wg, stop, alarmFile)
 	log.WriteLog(log.INFO, "", "Launch KpiRoutine")
-	go KpiRoutine(wg, kpiCounter)
+	go KpiRoutine(wg, stop, kpiCounter)
+	if len(*metrics_addr) > 0 {
+		wg.Add(1)
+		log.WriteLog(log.INFO, "", "Launch MetricsRoutine on [%s]", *metrics_addr)
+		go MetricsRoutine(wg, stop, *metrics_addr, kpiCounter, alarmFile)
+	}
 	log.WriteLog(log.CLEAN, "APP_START", "application startup normally")
-	wg.Wait()
-	log.WriteLog(log.INFO, "", "Exit ...")
+	shutdown(wg, log_stop, log_wg)
+	log.CloseLog()
 }
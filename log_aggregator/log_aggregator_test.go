@@ -0,0 +1,100 @@
+package main
+
+import (
+	log "applog"
+	"applog/applogtest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestShutdown(t *testing.T) {
+	clock := applogtest.NewFakeClock(time.Date(2016, 5, 14, 4, 10, 30, 0, time.Local))
+	h := applogtest.Setup(t, applogtest.Options{
+		AppName:  "AGGREGATOR",
+		AlarmOid: map[string]string{"DB_FAIL": "1.3.1.1.1"},
+		KpiOid:   map[string]string{"REQ_COUNT": "1.3.1.2.1"},
+		Clock:    clock,
+	})
+	router, err := log.NewLogRouter(h.Dir, "app.log", nil, log.RotatePolicy{Period: log.ROTATE_DAY}, false)
+	if err != nil {
+		t.Fatalf("NewLogRouter: %v", err)
+	}
+	kc, err := log.NewKpiFile()
+	if err != nil {
+		t.Fatalf("NewKpiFile: %v", err)
+	}
+	af := &log.AlarmFile{}
+
+	stop := make(chan struct{})
+	wg := &sync.WaitGroup{}
+	wg.Add(2)
+	log_stop := make(chan struct{})
+	log_wg := &sync.WaitGroup{}
+	log_wg.Add(1)
+	go LogRoutine(log_wg, log_stop, router)
+	go AlarmRoutine(wg, stop, af)
+	go KpiRoutine(wg, stop, kc)
+
+	log.WriteKpi("REQ_COUNT", 3)
+	log.WriteLog(log.ERROR, "DB_FAIL", "Failed to connect to db")
+	log.Info("pending on stop")
+	//the clock stands still, so only the drain on stop writes the files
+	close(stop)
+	shutdown(wg, log_stop, log_wg)
+
+	now := clock.Now()
+	kpi_file, _ := log.GenerateFileNameAt("KPI", now)
+	b, err := os.ReadFile(filepath.Join(h.Dir, kpi_file))
+	if err != nil || !strings.Contains(string(b), "|1.3.1.2.1|3") {
+		t.Fatalf("KPI file not flushed on shutdown: [%s] %v", string(b), err)
+	}
+	alarm_file, _ := log.GenerateFileNameAt("WARNING", now)
+	b, err = os.ReadFile(filepath.Join(h.Dir, alarm_file))
+	if err != nil || !strings.Contains(string(b), "|.1.3.1.1.1|Failed to connect to db") {
+		t.Fatalf("WARNING file not flushed on shutdown: [%s] %v", string(b), err)
+	}
+	b, err = os.ReadFile(filepath.Join(h.Dir, "app.log"))
+	if err != nil {
+		t.Fatalf("read app.log: %v", err)
+	}
+	//the lines logged by the KpiRoutine and AlarmRoutine on their shutdown are drained as well
+	for _, expect := range []string{"pending on stop", "Flush KPI file", "Write WARNING file", "Exit ..."} {
+		if !strings.Contains(string(b), expect) {
+			t.Fatalf("expect [%s] in app.log but [%s]", expect, string(b))
+		}
+	}
+}
+
+func TestHandleSignals(t *testing.T) {
+	applogtest.Setup(t, applogtest.Options{AppName: "AGGREGATOR"})
+	sig := make(chan os.Signal, 2)
+	stop := make(chan struct{})
+	exited := make(chan int, 1)
+	go handleSignals(sig, stop, func(code int) { exited <- code })
+
+	sig <- syscall.SIGTERM
+	select {
+	case <-stop:
+	case <-time.After(time.Second):
+		t.Fatalf("stop not closed on the first signal")
+	}
+	select {
+	case code := <-exited:
+		t.Fatalf("exit with %d on the first signal", code)
+	case <-time.After(50 * time.Millisecond):
+	}
+	sig <- syscall.SIGINT
+	select {
+	case code := <-exited:
+		if code != 1 {
+			t.Fatalf("expect exit code 1 but %d", code)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("not exit on the second signal")
+	}
+}
@@ -2,7 +2,11 @@ package applog
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestSimpleLog(t *testing.T) {
@@ -83,3 +87,166 @@ func TestWrite2MQ(t *testing.T) {
 	Db("call db to MQ: %d, %s, but this should be suppressed via DebugLog(false)", 10, "debug string")
 	Db("call db to MQ: %d, %s, but this should be suppressed via DebugLog(false)", 10, "debug string")
 }
+
+func TestNewLoggerStdout(t *testing.T) {
+	cfg := &LogCfg{
+		MQID:      7912,
+		Transport: TRANSPORT_MEMORY,
+		AlarmOid:  map[string]string{"DB_FAIL": "1.3.1.1.1"},
+	}
+	l, err := NewLogger(cfg, "STDOUT001", WithAsync(16, OVERFLOW_BLOCK))
+	if err != nil {
+		t.Fatalf("NewLogger: %v", err)
+	}
+	if l.queue.Load() == nil {
+		t.Fatalf("WithAsync ignored without WithLogFile")
+	}
+	l.WriteLog(ERROR, "DB_FAIL", "Failed to connect to db")
+	err = l.RaiseAlarm(ERROR, "DB_FAIL", "db1", "Failed to connect to db")
+	if err != nil {
+		t.Fatalf("RaiseAlarm: %v", err)
+	}
+	mt := NewMemTransport(cfg.MQID)
+	defer mt.Close()
+	l.Close() //drain the async queue
+	if alarms := mt.Drain(ALARM_MSG_TYPE); len(alarms) != 2 {
+		t.Fatalf("expect the alarms sent without log file but %q", alarms)
+	}
+	if logs := mt.Drain(LOG_MSG_TYPE); len(logs) != 0 {
+		t.Fatalf("log shall go to stdout but %q", logs)
+	}
+}
+
+func TestNewLogger(t *testing.T) {
+	cfg := &LogCfg{
+		MQID:      7889,
+		Transport: TRANSPORT_MEMORY,
+		LogPath:   t.TempDir(),
+		AlarmOid:  map[string]string{"DB_FAIL": "1.3.1.1.1"},
+		KpiOid:    map[string]string{"REQ_COUNT": "1.3.1.2.1"},
+	}
+	l1, err := NewLogger(cfg, "COMPONENT001", WithLogFile("component001.log"), WithDebug(true))
+	if err != nil {
+		t.Fatalf("NewLogger: %v", err)
+	}
+	defer l1.Close()
+	l2, err := NewLogger(cfg, "COMPONENT002", WithLogFile("mq"))
+	if err != nil {
+		t.Fatalf("NewLogger: %v", err)
+	}
+	defer l2.Close()
+
+	l1.Db("debug log of %s", "COMPONENT001")
+	l2.Db("debug log of %s shall be suppressed", "COMPONENT002")
+	l2.WriteLog(ERROR, "DB_FAIL", "Failed to connect to db [%s:%d]", "localhost", 3868)
+	err = l2.IncreaseKpi("REQ_COUNT")
+	if err != nil {
+		t.Fatalf("IncreaseKpi: %v", err)
+	}
+
+	l1.Flush()
+	b, err := os.ReadFile(filepath.Join(cfg.LogPath, "component001.log"))
+	if err != nil {
+		t.Fatalf("read log file: %v", err)
+	}
+	if !strings.HasSuffix(string(b), "|COMPONENT001|DEBUG|debug log of COMPONENT001\n") {
+		t.Fatalf("unexpected log file content [%s]", string(b))
+	}
+	mt := NewMemTransport(cfg.MQID)
+	logs := mt.Drain(LOG_MSG_TYPE)
+	if len(logs) != 1 || !strings.Contains(string(logs[0]), "|COMPONENT002|ERROR|") {
+		t.Fatalf("unexpected log records %q", logs)
+	}
+	if alarms := mt.Drain(ALARM_MSG_TYPE); len(alarms) != 1 {
+		t.Fatalf("unexpected alarm records %q", alarms)
+	}
+	if kpis := mt.Drain(KPI_MSG_TYPE); len(kpis) != 1 || string(kpis[0]) != "1.3.1.2.1|1" {
+		t.Fatalf("unexpected kpi records %q", kpis)
+	}
+}
+
+func TestLogFileReopen(t *testing.T) {
+	cfg := &LogCfg{MQID: 7892, Transport: TRANSPORT_MEMORY, LogPath: t.TempDir()}
+	l, err := NewLogger(cfg, "APPLICATION001", WithLogFile("reopen.log"), WithFlushInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewLogger: %v", err)
+	}
+	defer l.Close()
+	path := filepath.Join(cfg.LogPath, "reopen.log")
+
+	l.Info("before switch")
+	time.Sleep(50 * time.Millisecond)
+	err = os.Rename(path, path+".1")
+	if err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	l.Info("after switch")
+	l.Flush()
+
+	b, err := os.ReadFile(path + ".1")
+	if err != nil || !strings.HasSuffix(string(b), "|INFO|before switch\n") {
+		t.Fatalf("unexpected switched log file [%s] %v", string(b), err)
+	}
+	b, err = os.ReadFile(path)
+	if err != nil || !strings.HasSuffix(string(b), "|INFO|after switch\n") {
+		t.Fatalf("unexpected new log file [%s] %v", string(b), err)
+	}
+}
+
+func TestLogFileFlushOnError(t *testing.T) {
+	cfg := &LogCfg{MQID: 7909, Transport: TRANSPORT_MEMORY, LogPath: t.TempDir()}
+	l, err := NewLogger(cfg, "APPLICATION001", WithLogFile("flush.log"), WithFlushInterval(time.Hour))
+	if err != nil {
+		t.Fatalf("NewLogger: %v", err)
+	}
+	defer l.Close()
+	path := filepath.Join(cfg.LogPath, "flush.log")
+
+	l.Info("buffered")
+	b, _ := os.ReadFile(path)
+	if len(b) != 0 {
+		t.Fatalf("INFO log shall be buffered until the flush but [%s]", string(b))
+	}
+	l.WriteLog(ERROR, NO_ALARM, "flushed")
+	b, _ = os.ReadFile(path)
+	if !strings.Contains(string(b), "|INFO|buffered\n") || !strings.HasSuffix(string(b), "|ERROR|flushed\n") {
+		t.Fatalf("ERROR log shall flush the buffered lines but [%s]", string(b))
+	}
+
+	//a moved file is noticed by the ERROR log before the flush tick
+	os.Rename(path, path+".1")
+	l.WriteLog(FATAL, NO_ALARM, "after switch")
+	b, err = os.ReadFile(path)
+	if err != nil || !strings.HasSuffix(string(b), "|FATAL|after switch\n") {
+		t.Fatalf("unexpected new log file [%s] %v", string(b), err)
+	}
+}
+
+func BenchmarkWriteLogFile(b *testing.B) {
+	cfg := &LogCfg{MQID: 7893, Transport: TRANSPORT_MEMORY, LogPath: b.TempDir()}
+	l, err := NewLogger(cfg, "BENCH", WithLogFile("bench.log"))
+	if err != nil {
+		b.Fatalf("NewLogger: %v", err)
+	}
+	defer l.Close()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		l.WriteLog(INFO, NO_ALARM, "%s [%d]", "info log", i)
+	}
+}
+
+/*the open/close per line behavior before the log file is kept open, as the baseline of BenchmarkWriteLogFile*/
+func BenchmarkWriteLogOpenPerLine(b *testing.B) {
+	path := filepath.Join(b.TempDir(), "bench.log")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		line := time.Now().Format("20060102-150405.000") + "|BENCH|" + Level2Str(INFO) + "|" + fmt.Sprintf("%s [%d]", "info log", i)
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			b.Fatalf("OpenFile: %v", err)
+		}
+		f.Write([]byte(line + "\n"))
+		f.Close()
+	}
+}
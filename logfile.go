@@ -0,0 +1,281 @@
+package applog
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	DEFAULT_FLUSH_INTERVAL = time.Second
+	LOG_FILE_BUFFER_SIZE   = 32 * 1024
+
+	ROTATE_NONE = ""
+	ROTATE_DAY  = "day"
+	ROTATE_HOUR = "hour"
+)
+
+/*Rotation policy of log file, the rotated files are named as [file].YYYYMMDD, [file].YYYYMMDDHH per period*/
+type RotatePolicy struct {
+	MaxSize    int64  `json:"max_size"`     //rotate when the file exceeds the bytes, 0 for no limit
+	Period     string `json:"period"`       //rotate on "day" or "hour" boundary, "" for no period rotation
+	MaxFiles   int    `json:"max_files"`    //number of rotated files retained, 0 for no limit
+	MaxAgeDays int    `json:"max_age_days"` //days the rotated files retained, 0 for no limit
+}
+
+func (self *RotatePolicy) Validate() error {
+	switch self.Period {
+	case ROTATE_NONE, ROTATE_DAY, ROTATE_HOUR:
+	default:
+		return errors.New(fmt.Sprintf("RotatePolicy: invalid period [%s]", self.Period))
+	}
+	if self.MaxSize < 0 || self.MaxFiles < 0 || self.MaxAgeDays < 0 {
+		return errors.New("RotatePolicy: max_size, max_files and max_age_days shall not be negative")
+	}
+	return nil
+}
+
+/*the suffix of the rotated file of the period t is in*/
+func (self *RotatePolicy) stamp(t time.Time) string {
+	switch self.Period {
+	case ROTATE_DAY:
+		return t.Format("20060102")
+	case ROTATE_HOUR:
+		return t.Format("2006010215")
+	default:
+		return t.Format("20060102150405")
+	}
+}
+
+/*Log file kept open with a buffered writer, rotated per the policy, reopened if renamed away*/
+type RotatingFile struct {
+	path   string
+	policy RotatePolicy
+	f      *os.File
+	w      *bufio.Writer
+	size   int64
+	period string //stamp of the period the current file is written in
+	hook   func(rotated string)
+}
+
+func NewRotatingFile(path string, policy RotatePolicy) (*RotatingFile, error) {
+	err := policy.Validate()
+	if err != nil {
+		return nil, err
+	}
+	rf := &RotatingFile{path: path, policy: policy}
+	err = rf.open()
+	if err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (self *RotatingFile) Path() string {
+	return self.path
+}
+
+/*Set the function called with the rotated filename after each rotation*/
+func (self *RotatingFile) SetRotateHook(hook func(rotated string)) {
+	self.hook = hook
+}
+
+func (self *RotatingFile) open() error {
+	f, err := os.OpenFile(self.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	s, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	self.f = f
+	self.w = bufio.NewWriterSize(f, LOG_FILE_BUFFER_SIZE)
+	self.size = s.Size()
+	if s.Size() > 0 {
+		self.period = self.policy.stamp(s.ModTime()) //the file left by last run
+	} else {
+		self.period = self.policy.stamp(Now())
+	}
+	return nil
+}
+
+func (self *RotatingFile) Write(b []byte) (int, error) {
+	if self.f == nil {
+		err := self.open()
+		if err != nil {
+			return 0, err
+		}
+	}
+	err := self.RotateIfDue()
+	if err != nil {
+		return 0, err
+	}
+	if self.policy.MaxSize > 0 && self.size > 0 && self.size+int64(len(b)) > self.policy.MaxSize {
+		err := self.rotate(self.policy.stamp(Now()))
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := self.w.Write(b)
+	self.size += int64(n)
+	return n, err
+}
+
+/*Rotate the file if its period is passed, e.g. on the flush tick of a quiet day, an empty file is kept*/
+func (self *RotatingFile) RotateIfDue() error {
+	if self.f == nil || self.policy.Period == ROTATE_NONE {
+		return nil
+	}
+	now := self.policy.stamp(Now())
+	if now == self.period {
+		return nil
+	}
+	if self.size > 0 {
+		err := self.rotate(self.period)
+		if err != nil {
+			return err
+		}
+	}
+	self.period = now
+	return nil
+}
+
+/*rename the current file to [file].[stamp], with sequence appended if exists, then open a new one*/
+func (self *RotatingFile) rotate(stamp string) error {
+	self.Close()
+	target := self.path + "." + stamp
+	for i := 1; ; i++ {
+		_, err := os.Stat(target)
+		if os.IsNotExist(err) {
+			break
+		}
+		target = fmt.Sprintf("%s.%s.%d", self.path, stamp, i)
+	}
+	err := os.Rename(self.path, target)
+	if err != nil {
+		return errors.New(fmt.Sprintf("RotatingFile: rotate [%s] failed: %v", self.path, err))
+	}
+	err = self.open()
+	if err != nil {
+		return err
+	}
+	self.period = self.policy.stamp(Now())
+	PruneRotated(self.path, self.policy.MaxFiles, self.policy.MaxAgeDays)
+	if self.hook != nil {
+		self.hook(target)
+	}
+	return nil
+}
+
+func (self *RotatingFile) Flush() error {
+	if self.f == nil {
+		return nil
+	}
+	return self.w.Flush()
+}
+
+/*flush and reopen the file if the path no longer refers to the opened file, e.g. it is renamed for switching*/
+func (self *RotatingFile) ReopenIfMoved() error {
+	if self.f == nil {
+		return self.open()
+	}
+	cur, err := self.f.Stat()
+	if err != nil {
+		return err
+	}
+	s, err := os.Stat(self.path)
+	if err == nil && os.SameFile(cur, s) {
+		return nil
+	}
+	self.Close()
+	return self.open()
+}
+
+func (self *RotatingFile) Close() error {
+	if self.f == nil {
+		return nil
+	}
+	self.w.Flush()
+	err := self.f.Close()
+	self.f = nil
+	self.w = nil
+	return err
+}
+
+// Remove the rotated files [path].* beyond the max_files newest ones or older than max_age_days,
+// return the removed files
+func PruneRotated(path string, max_files int, max_age_days int) ([]string, error) {
+	if max_files <= 0 && max_age_days <= 0 {
+		return nil, nil
+	}
+	files, err := listRotated(path)
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	deadline := Now().Add(-time.Duration(max_age_days) * 24 * time.Hour)
+	for i, fi := range files {
+		if (max_files > 0 && i >= max_files) || (max_age_days > 0 && fi.mtime.Before(deadline)) {
+			err = os.Remove(fi.path)
+			if err == nil {
+				removed = append(removed, fi.path)
+			}
+		}
+	}
+	return removed, nil
+}
+
+type rotatedFile struct {
+	path  string
+	size  int64
+	mtime time.Time
+	stamp string //the period stamp in the name
+	seq   int    //the sequence of the rotations in the same period, 0 for the first one
+}
+
+/*[file].[stamp][.seq][.gz][.tmp], the stamp is YYYYMMDD, YYYYMMDDHH or YYYYMMDDHHMISS*/
+func rotatedNameRe(base string) *regexp.Regexp {
+	return regexp.MustCompile(`^` + regexp.QuoteMeta(base) + `\.(\d{8}|\d{10}|\d{14})(?:\.(\d+))?(?:\.gz)?(?:\.tmp)?$`)
+}
+
+/*list the rotated files [path].*, newest first*/
+func listRotated(path string) ([]rotatedFile, error) {
+	dir := filepath.Dir(path)
+	re := rotatedNameRe(filepath.Base(path))
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []rotatedFile
+	for _, e := range entries {
+		m := re.FindStringSubmatch(e.Name())
+		if e.IsDir() || m == nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		seq, _ := strconv.Atoi(m[2])
+		files = append(files, rotatedFile{filepath.Join(dir, e.Name()), info.Size(), info.ModTime(), m[1], seq})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if !files[i].mtime.Equal(files[j].mtime) {
+			return files[i].mtime.After(files[j].mtime)
+		}
+		//rotated in the same tick, the later period or sequence is newer
+		if files[i].stamp != files[j].stamp {
+			return files[i].stamp > files[j].stamp
+		}
+		return files[i].seq > files[j].seq
+	})
+	return files, nil
+}
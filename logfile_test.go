@@ -0,0 +1,74 @@
+package applog
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRotatingFileSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	rf, err := NewRotatingFile(path, RotatePolicy{MaxSize: 20, Period: ROTATE_DAY, MaxFiles: 2})
+	if err != nil {
+		t.Fatalf("NewRotatingFile: %v", err)
+	}
+	defer rf.Close()
+	for i := 0; i < 4; i++ {
+		rf.Write([]byte("0123456789abcdef\n")) //17 bytes, each line rotates the last one
+	}
+	rf.Flush()
+
+	files, err := listRotated(path)
+	if err != nil {
+		t.Fatalf("listRotated: %v", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("expect 2 rotated files retained but %v", files)
+	}
+	day := time.Now().Format("20060102")
+	for _, name := range []string{path, path + "." + day + ".1", path + "." + day + ".2"} {
+		b, err := os.ReadFile(name)
+		if err != nil || string(b) != "0123456789abcdef\n" {
+			t.Fatalf("unexpected content of [%s]: [%s] %v", name, string(b), err)
+		}
+	}
+}
+
+func TestPruneRotatedByAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	old := time.Now().Add(-72 * time.Hour)
+	for _, suffix := range []string{".20160516", ".20160517", ".20160518"} {
+		os.WriteFile(path+suffix, []byte("log\n"), 0644)
+	}
+	os.Chtimes(path+".20160516", old, old)
+	removed, err := PruneRotated(path, 0, 2)
+	if err != nil {
+		t.Fatalf("PruneRotated: %v", err)
+	}
+	if len(removed) != 1 || removed[0] != path+".20160516" {
+		t.Fatalf("unexpected removed files %v", removed)
+	}
+}
+
+func TestPruneRotatedSameTick(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	mtime := time.Now().Add(-time.Hour)
+	for _, suffix := range []string{".20160518.2", ".20160518", ".20160518.1", ".bak", ".20160518.old"} {
+		os.WriteFile(path+suffix, []byte("log\n"), 0644)
+		os.Chtimes(path+suffix, mtime, mtime) //rotated in the same tick
+	}
+	removed, err := PruneRotated(path, 1, 0)
+	if err != nil {
+		t.Fatalf("PruneRotated: %v", err)
+	}
+	if len(removed) != 2 || removed[0] != path+".20160518.1" || removed[1] != path+".20160518" {
+		t.Fatalf("unexpected removed files %v", removed)
+	}
+	for _, suffix := range []string{".20160518.2", ".bak", ".20160518.old"} {
+		_, err = os.Stat(path + suffix)
+		if err != nil {
+			t.Fatalf("[%s] shall be kept: %v", path+suffix, err)
+		}
+	}
+}
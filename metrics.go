@@ -0,0 +1,182 @@
+package applog
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	METRICS_PATH = "/metrics"
+
+	METRIC_KPI_LAST_END     = "applog_kpi_last_interval_end_seconds"
+	METRIC_ALARM_LAST_RAISE = "applog_alarm_last_raised_seconds"
+	METRIC_ALARM_RAISED     = "applog_alarm_raised_total"
+	METRIC_ALARM_STATE      = "applog_alarm_active"
+	METRIC_ALARM_ACTIVE     = "applog_alarm_active_since_seconds"
+)
+
+var metric_name_re = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
+
+/*convert the kpi name to a valid prometheus metric name*/
+func metricName(kpi_name string) string {
+	name := metric_name_re.ReplaceAllString(kpi_name, "_")
+	if len(name) < 1 || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+	return name
+}
+
+/*escape the prometheus label value*/
+func labelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+/*the kpi name of the oid, or of the kpi oid the oid is a sub-oid of, e.g. a label combination or a histogram row*/
+func kpiNameOf(oid string, names map[string]string) (string, bool) {
+	for base := oid; ; {
+		name, present := names[base]
+		if present {
+			return name, true
+		}
+		i := strings.LastIndex(base, ".")
+		if i < 0 {
+			return "", false
+		}
+		base = base[:i]
+	}
+}
+
+/*MetricsHandler serves the kpi counters of the current and the last interval and the alarm state in the prometheus text format*/
+type MetricsHandler struct {
+	kpi   *KpiFile
+	alarm *AlarmFile
+}
+
+/*Create the handler of the kpi file and alarm file processed by log_aggregator, either can be nil*/
+func NewMetricsHandler(kf *KpiFile, af *AlarmFile) *MetricsHandler {
+	return &MetricsHandler{kpi: kf, alarm: af}
+}
+
+func (self *MetricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	self.writeKpis(&buf)
+	self.writeAlarms(&buf)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(buf.Bytes())
+}
+
+/*one gauge per kpi name with a sample per oid and interval, and one histogram per histogram kpi name*/
+func (self *MetricsHandler) writeKpis(buf *bytes.Buffer) {
+	if self.kpi == nil || g_log_cfg == nil {
+		return
+	}
+	names := make(map[string]string) //oid to kpi name
+	for name, oid := range g_log_cfg.KpiOid {
+		names[oid] = name
+	}
+	current := self.kpi.Counters()
+	last, last_end := self.kpi.LastCounters()
+	samples := make(map[string][]string)  //metric name to samples
+	histograms := make(map[string]string) //metric name to the oid of the histogram kpi
+	add := func(counters map[string]int64, interval string) {
+		for oid, v := range counters {
+			name, present := kpiNameOf(oid, names)
+			if !present {
+				continue
+			}
+			metric := metricName(name)
+			if base := g_log_cfg.KpiOid[name]; g_log_cfg.GetKpiKind(base) == KPI_HISTOGRAM {
+				histograms[metric] = base
+				continue
+			}
+			samples[metric] = append(samples[metric], fmt.Sprintf("%s{oid=\"%s\",interval=\"%s\"} %d\n", metric, labelValue(oid), interval, v))
+		}
+	}
+	add(current, "current")
+	add(last, "last")
+	metrics := make([]string, 0, len(samples))
+	for metric, _ := range samples {
+		metrics = append(metrics, metric)
+	}
+	sort.Strings(metrics)
+	for _, metric := range metrics {
+		sort.Strings(samples[metric])
+		fmt.Fprintf(buf, "# TYPE %s gauge\n", metric)
+		for _, sample := range samples[metric] {
+			buf.WriteString(sample)
+		}
+	}
+	metrics = metrics[:0]
+	for metric, _ := range histograms {
+		metrics = append(metrics, metric)
+	}
+	sort.Strings(metrics)
+	for _, metric := range metrics {
+		oid := histograms[metric]
+		fmt.Fprintf(buf, "# TYPE %s histogram\n", metric)
+		writeHistogram(buf, metric, oid, "current", current)
+		if _, present := last[oid+KPI_SUB_COUNT]; present {
+			writeHistogram(buf, metric, oid, "last", last)
+		}
+	}
+	if !last_end.IsZero() {
+		fmt.Fprintf(buf, "# TYPE %s gauge\n%s %d\n", METRIC_KPI_LAST_END, METRIC_KPI_LAST_END, last_end.Unix())
+	}
+}
+
+/*
+the rows of the histogram kpi oid as the cumulative buckets, the sum and the count of a prometheus histogram,
+the bucket rows (boundary N-1, boundary N] add up to le="boundary N", the min, max, avg and percentile rows are not exported
+*/
+func writeHistogram(buf *bytes.Buffer, metric string, oid string, interval string, rows map[string]int64) {
+	labels := fmt.Sprintf("oid=\"%s\",interval=\"%s\"", labelValue(oid), interval)
+	cumulative := int64(0)
+	for i, bound := range g_log_cfg.GetKpiBuckets(oid) {
+		cumulative += rows[fmt.Sprintf("%s%s%d", oid, KPI_SUB_BUCKET, i+1)]
+		fmt.Fprintf(buf, "%s_bucket{%s,le=\"%d\"} %d\n", metric, labels, bound, cumulative)
+	}
+	fmt.Fprintf(buf, "%s_bucket{%s,le=\"+Inf\"} %d\n", metric, labels, rows[oid+KPI_SUB_COUNT])
+	fmt.Fprintf(buf, "%s_sum{%s} %d\n", metric, labels, rows[oid+KPI_SUB_SUM])
+	fmt.Fprintf(buf, "%s_count{%s} %d\n", metric, labels, rows[oid+KPI_SUB_COUNT])
+}
+
+/*the last raised time, the count and the active state of the alarms per oid, and the first raised time of each active alarm*/
+func (self *MetricsHandler) writeAlarms(buf *bytes.Buffer) {
+	if self.alarm == nil {
+		return
+	}
+	active := self.alarm.ActiveAlarms()
+	if len(active) > 0 {
+		fmt.Fprintf(buf, "# TYPE %s gauge\n", METRIC_ALARM_ACTIVE)
+		for _, a := range active {
+			fmt.Fprintf(buf, "%s{oid=\"%s\",resource=\"%s\",level=\"%s\"} %d\n", METRIC_ALARM_ACTIVE, labelValue(a.Oid), labelValue(a.Resource), labelValue(a.Level), a.FirstRaised.Unix())
+		}
+	}
+	states := self.alarm.States()
+	if len(states) == 0 {
+		return
+	}
+	labels := func(s AlarmState) string {
+		return fmt.Sprintf("{oid=\"%s\"}", labelValue(s.Oid))
+	}
+	fmt.Fprintf(buf, "# TYPE %s gauge\n", METRIC_ALARM_LAST_RAISE)
+	for _, s := range states {
+		fmt.Fprintf(buf, "%s%s %d\n", METRIC_ALARM_LAST_RAISE, labels(s), s.LastRaised.Unix())
+	}
+	fmt.Fprintf(buf, "# TYPE %s counter\n", METRIC_ALARM_RAISED)
+	for _, s := range states {
+		fmt.Fprintf(buf, "%s%s %d\n", METRIC_ALARM_RAISED, labels(s), s.Count)
+	}
+	fmt.Fprintf(buf, "# TYPE %s gauge\n", METRIC_ALARM_STATE)
+	for _, s := range states {
+		active := 0
+		if s.Active {
+			active = 1
+		}
+		fmt.Fprintf(buf, "%s%s %d\n", METRIC_ALARM_STATE, labels(s), active)
+	}
+}
@@ -0,0 +1,85 @@
+package applog
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	cfg := loadTestCfg(t, 7904, func(cfg *LogCfg) {
+		cfg.AlarmOid = map[string]string{"DB_FAIL": "1.3.1.1.1"}
+		cfg.KpiOid["REQ-LATENCY"] = "1.3.1.2.9"
+		cfg.KpiType = map[string]string{"REQ-LATENCY": KPI_HISTOGRAM}
+		cfg.KpiBuckets = map[string][]int64{"REQ-LATENCY": {10, 100}}
+	})
+	err := InitLog("mq", "APPLICATION001")
+	if err != nil {
+		t.Fatalf("InitLog: %v", err)
+	}
+	kf, err := NewKpiFile()
+	if err != nil {
+		t.Fatalf("NewKpiFile: %v", err)
+	}
+	interval := time.Duration(cfg.KpiInterval) * time.Second
+	kf.schedule = NewFlushSchedule(cfg.KpiInterval, time.Now().Add(-interval))
+	end := kf.schedule.Next()
+	WriteKpi("REQ_COUNT", 5)
+	kf.Process()
+	kf.Flush()
+	WriteKpi("REQ_COUNT", 2)
+	ObserveKpi("REQ-LATENCY", 30)
+	kf.Process()
+	af := &AlarmFile{}
+	t.Cleanup(func() { SetClock(nil) })
+	raised := time.Date(2016, 5, 18, 10, 1, 0, 0, time.Local)
+	SetClock(fixedClock(raised))
+	WriteLog(ERROR, "DB_FAIL", "Failed to connect to db")
+	WriteLog(ERROR, "DB_FAIL", "Failed to connect to db")
+	af.Process()
+
+	w := httptest.NewRecorder()
+	NewMetricsHandler(kf, af).ServeHTTP(w, httptest.NewRequest("GET", METRICS_PATH, nil))
+	body := w.Body.String()
+	for _, expect := range []string{
+		"# TYPE REQ_COUNT gauge\n",
+		`REQ_COUNT{oid="1.3.1.2.1",interval="current"} 2` + "\n",
+		`REQ_COUNT{oid="1.3.1.2.1",interval="last"} 5` + "\n",
+		"# TYPE REQ_LATENCY histogram\n",
+		`REQ_LATENCY_bucket{oid="1.3.1.2.9",interval="current",le="10"} 0` + "\n",
+		`REQ_LATENCY_bucket{oid="1.3.1.2.9",interval="current",le="100"} 1` + "\n",
+		`REQ_LATENCY_bucket{oid="1.3.1.2.9",interval="current",le="+Inf"} 1` + "\n",
+		`REQ_LATENCY_sum{oid="1.3.1.2.9",interval="current"} 30` + "\n",
+		`REQ_LATENCY_count{oid="1.3.1.2.9",interval="last"} 0` + "\n",
+		fmt.Sprintf("%s %d\n", METRIC_KPI_LAST_END, end.Unix()),
+		METRIC_ALARM_RAISED + `{oid="1.3.1.1.1"} 2` + "\n",
+		METRIC_ALARM_STATE + `{oid="1.3.1.1.1"} 1` + "\n",
+	} {
+		if !strings.Contains(body, expect) {
+			t.Fatalf("expect [%s] in\n%s", expect, body)
+		}
+	}
+	if strings.Contains(body, "REQ_LATENCY{") {
+		t.Fatalf("histogram rows exported as gauge\n%s", body)
+	}
+
+	//the CLEAN and EVENT records are neither counted nor stamped as raised
+	SetClock(fixedClock(raised.Add(time.Minute)))
+	WriteLog(CLEAN, "DB_FAIL", "Connected to db")
+	WriteLog(EVENT, "DB_FAIL", "Reconnected to db")
+	af.Process()
+	w = httptest.NewRecorder()
+	NewMetricsHandler(kf, af).ServeHTTP(w, httptest.NewRequest("GET", METRICS_PATH, nil))
+	body = w.Body.String()
+	for _, expect := range []string{
+		fmt.Sprintf("%s{oid=\"1.3.1.1.1\"} %d\n", METRIC_ALARM_LAST_RAISE, raised.Unix()),
+		METRIC_ALARM_RAISED + `{oid="1.3.1.1.1"} 2` + "\n",
+		METRIC_ALARM_STATE + `{oid="1.3.1.1.1"} 0` + "\n",
+	} {
+		if !strings.Contains(body, expect) {
+			t.Fatalf("expect [%s] in\n%s", expect, body)
+		}
+	}
+}
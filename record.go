@@ -0,0 +1,200 @@
+package applog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const BAD_KEY = "!BADKEY"
+
+/*A structured key/value field of a log record*/
+type Field struct {
+	Key   string
+	Value string
+}
+
+// A log record, rendered as "ts|app|level|msg" or "ts|app|level|msg|k1=v1 k2=v2" with fields.
+// With fields, '|' and '\' in msg are escaped by '\', the values containing space, '"', '=' or '|' are quoted
+type LogRecord struct {
+	Timestamp string
+	App       string
+	Level     string
+	Msg       string
+	Fields    []Field
+}
+
+/*Build fields from alternating key/value pairs, a trailing value without key gets the key "!BADKEY"*/
+func KV2Fields(kv ...interface{}) []Field {
+	var fields []Field
+	for i := 0; i < len(kv); i += 2 {
+		if i+1 >= len(kv) {
+			fields = append(fields, Field{BAD_KEY, fmt.Sprint(kv[i])})
+			break
+		}
+		fields = append(fields, Field{fmt.Sprint(kv[i]), fmt.Sprint(kv[i+1])})
+	}
+	return fields
+}
+
+/*Render the fields in logfmt style: k1=v1 k2="v 2"*/
+func FormatFields(fields []Field) string {
+	var sb strings.Builder
+	for i, f := range fields {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(sanitizeKey(f.Key))
+		sb.WriteByte('=')
+		if needQuote(f.Value) {
+			sb.WriteString(strconv.Quote(f.Value))
+		} else {
+			sb.WriteString(f.Value)
+		}
+	}
+	return sb.String()
+}
+
+func sanitizeKey(k string) string {
+	if len(k) < 1 {
+		return BAD_KEY
+	}
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r == '=' || r == '"' || r == '|' || r == 0x7f {
+			return '_'
+		}
+		return r
+	}, k)
+}
+
+func needQuote(v string) bool {
+	if len(v) < 1 {
+		return true
+	}
+	for _, r := range v {
+		if r <= ' ' || r == '=' || r == '"' || r == '|' || r == '\\' || r == 0x7f {
+			return true
+		}
+	}
+	return false
+}
+
+/*parse the logfmt fields, return error if any token is not a key=value pair*/
+func parseFields(s string) ([]Field, error) {
+	var fields []Field
+	for {
+		s = strings.TrimLeft(s, " ")
+		if len(s) < 1 {
+			break
+		}
+		eq := strings.IndexByte(s, '=')
+		if eq < 1 || strings.ContainsAny(s[:eq], " \"|") {
+			return nil, errors.New("parseFields: invalid key")
+		}
+		key := s[:eq]
+		s = s[eq+1:]
+		var value string
+		if strings.HasPrefix(s, "\"") {
+			quoted, err := strconv.QuotedPrefix(s)
+			if err != nil {
+				return nil, err
+			}
+			value, _ = strconv.Unquote(quoted)
+			s = s[len(quoted):]
+			if len(s) > 0 && s[0] != ' ' {
+				return nil, errors.New("parseFields: invalid quoted value")
+			}
+		} else {
+			end := strings.IndexByte(s, ' ')
+			if end < 0 {
+				end = len(s)
+			}
+			value = s[:end]
+			s = s[end:]
+		}
+		fields = append(fields, Field{key, value})
+	}
+	return fields, nil
+}
+
+func escapeMsg(msg string) string {
+	return strings.NewReplacer("\\", "\\\\", "|", "\\|").Replace(msg)
+}
+
+func unescapeMsg(msg string) string {
+	return strings.NewReplacer("\\\\", "\\", "\\|", "|").Replace(msg)
+}
+
+/*index of the last '|' not escaped by '\'*/
+func lastUnescapedPipe(s string) int {
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i] != '|' {
+			continue
+		}
+		n := 0
+		for j := i - 1; j >= 0 && s[j] == '\\'; j-- {
+			n++
+		}
+		if n%2 == 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+/*the message with fields appended in logfmt style, used in alarm records*/
+func (self *LogRecord) Content() string {
+	if len(self.Fields) < 1 {
+		return self.Msg
+	}
+	return self.Msg + " " + FormatFields(self.Fields)
+}
+
+/*Render the record as pipe-delimited line without line break*/
+func (self *LogRecord) String() string {
+	if len(self.Fields) < 1 {
+		return self.Timestamp + "|" + self.App + "|" + self.Level + "|" + self.Msg
+	}
+	return self.Timestamp + "|" + self.App + "|" + self.Level + "|" + escapeMsg(self.Msg) + "|" + FormatFields(self.Fields)
+}
+
+func (self *LogRecord) MarshalJSON() ([]byte, error) {
+	var fields map[string]string
+	if len(self.Fields) > 0 {
+		fields = make(map[string]string)
+		for _, f := range self.Fields {
+			fields[f.Key] = f.Value
+		}
+	}
+	return json.Marshal(&struct {
+		Timestamp string            `json:"ts"`
+		App       string            `json:"app"`
+		Level     string            `json:"level"`
+		Msg       string            `json:"msg"`
+		Fields    map[string]string `json:"fields,omitempty"`
+	}{self.Timestamp, self.App, self.Level, self.Msg, fields})
+}
+
+// Parse a pipe-delimited log line back to LogRecord.
+// The trailing column is taken as fields only if it is fully in key=value form
+func ParseLogLine(line string) (*LogRecord, error) {
+	line = strings.TrimRight(line, "\r\n")
+	sv := strings.SplitN(line, "|", 4)
+	if len(sv) != 4 {
+		return nil, errors.New(fmt.Sprintf("ParseLogLine: invalid log line [%s]", line))
+	}
+	rec := &LogRecord{Timestamp: sv[0], App: sv[1], Level: sv[2], Msg: sv[3]}
+	i := lastUnescapedPipe(sv[3])
+	if i < 0 {
+		return rec, nil
+	}
+	fields, err := parseFields(sv[3][i+1:])
+	if err != nil || len(fields) < 1 {
+		return rec, nil //legacy line with '|' in content
+	}
+	rec.Msg = unescapeMsg(sv[3][:i])
+	rec.Fields = fields
+	return rec, nil
+}
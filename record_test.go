@@ -0,0 +1,44 @@
+package applog
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestLogRecord(t *testing.T) {
+	rec := &LogRecord{
+		Timestamp: "20160518-150820.047",
+		App:       "APPLICATION001",
+		Level:     "INFO",
+		Msg:       "request done | ok",
+		Fields:    KV2Fields("user", 42, "latency_ms", 12, "peer", "hss 1", "dangling"),
+	}
+	line := rec.String()
+	expect := `20160518-150820.047|APPLICATION001|INFO|request done \| ok|user=42 latency_ms=12 peer="hss 1" !BADKEY=dangling`
+	if line != expect {
+		t.Fatalf("expect [%s] but [%s]", expect, line)
+	}
+	parsed, err := ParseLogLine(line + "\n")
+	if err != nil {
+		t.Fatalf("ParseLogLine: %v", err)
+	}
+	if parsed.Msg != rec.Msg || len(parsed.Fields) != 4 || parsed.Fields[2].Value != "hss 1" {
+		t.Fatalf("unexpected parsed record %+v", parsed)
+	}
+
+	legacy := "20160518-150820.047|APPLICATION002|ERROR|a legacy | message"
+	parsed, err = ParseLogLine(legacy)
+	if err != nil {
+		t.Fatalf("ParseLogLine: %v", err)
+	}
+	if parsed.Msg != "a legacy | message" || len(parsed.Fields) != 0 {
+		t.Fatalf("unexpected parsed legacy record %+v", parsed)
+	}
+
+	parsed, _ = ParseLogLine(line)
+	b, err := json.Marshal(parsed)
+	if err != nil || !strings.Contains(string(b), `"fields":{"!BADKEY":"dangling","latency_ms":"12","peer":"hss 1","user":"42"}`) {
+		t.Fatalf("unexpected json line %s, %v", b, err)
+	}
+}
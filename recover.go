@@ -0,0 +1,97 @@
+package applog
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	QUARANTINE_DIR        = "quarantine"        //under alarm_kpi_path
+	QUARANTINE_ALARM_FILE = "alarm_records.txt" //the alarm records not parsed, if alarm_format is not pipe
+)
+
+// Recover the .kpi.tmp and .alarm.tmp files left by a crash of last run.
+// A tmp file valid for the configured formatter is published as the KPI/WARNING file
+// named by its interval if the formatter is a KpiPeriodReader, e.g. 3gpp, or by its mtime,
+// otherwise it is moved to the quarantine dir under alarm_kpi_path.
+// Return the actions taken.
+func RecoverTmpFiles() ([]string, error) {
+	if g_log_cfg == nil {
+		return nil, errors.New("RecoverTmpFiles failed, config not loaded")
+	}
+	var actions []string
+	kf := kpiFormatter()
+	af := alarmFormatter()
+	kpi_name := func(content []byte, t time.Time, rc int) (string, error) {
+		if pr, ok := kf.(KpiPeriodReader); ok {
+			begin, end, err := pr.Period(content)
+			if err == nil {
+				return kf.FileName(begin, end, rc)
+			}
+		}
+		return kf.FileName(t, t, rc)
+	}
+	alarm_name := func(content []byte, t time.Time, rc int) (string, error) { return af.FileName(t, rc) }
+	for _, tmp := range []struct {
+		name    string
+		valid   func(content []byte) bool
+		name_at func(content []byte, t time.Time, rc int) (string, error)
+	}{
+		{".kpi.tmp", kf.Valid, kpi_name},
+		{".alarm.tmp", af.Valid, alarm_name},
+	} {
+		action, err := recoverTmpFile(filepath.Join(g_log_cfg.AlarmKpiPath, tmp.name), tmp.valid, tmp.name_at)
+		if err != nil {
+			WriteLog(ERROR, "RECOVER_FAIL", "RecoverTmpFiles: %v", err)
+			return actions, err
+		}
+		if len(action) > 0 {
+			Info("RecoverTmpFiles: %s", action)
+			actions = append(actions, action)
+		}
+	}
+	os.Remove(filepath.Join(g_log_cfg.AlarmKpiPath, KPI_STATE_FILE+".tmp"))
+	os.Remove(filepath.Join(g_log_cfg.AlarmKpiPath, ALARM_STATE_FILE+".tmp"))
+	return actions, nil
+}
+
+func recoverTmpFile(path string, valid func(content []byte) bool, name_at func(content []byte, t time.Time, rc int) (string, error)) (string, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if info.Size() == 0 {
+		os.Remove(path)
+		return fmt.Sprintf("removed empty [%s]", path), nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	dir := g_log_cfg.AlarmKpiPath
+	verb := "published"
+	if !valid(b) {
+		dir = filepath.Join(g_log_cfg.AlarmKpiPath, QUARANTINE_DIR)
+		err = os.MkdirAll(dir, 0755)
+		if err != nil {
+			return "", err
+		}
+		verb = "quarantined"
+	}
+	target, err := freeName(dir, func(rc int) (string, error) { return name_at(b, info.ModTime(), rc) })
+	if err != nil {
+		return "", err
+	}
+	err = os.Rename(path, target)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s [%s] as [%s]", verb, path, target), nil
+}
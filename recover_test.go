@@ -0,0 +1,40 @@
+package applog
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecoverTmpFiles(t *testing.T) {
+	cfg := loadTestCfg(t, 7896)
+	mtime := time.Date(2016, 5, 18, 15, 25, 0, 0, time.Local)
+	kpi_tmp := filepath.Join(cfg.AlarmKpiPath, ".kpi.tmp")
+	alarm_tmp := filepath.Join(cfg.AlarmKpiPath, ".alarm.tmp")
+	os.WriteFile(kpi_tmp, []byte("20160518152500|kpi_collector|KPI|1.3.1.2.1|201\n"), 0644)
+	os.WriteFile(alarm_tmp, []byte("20160518162713|APPLICATION001|ERROR|.1.3.1.1.1|Failed to con"), 0644)
+	os.Chtimes(kpi_tmp, mtime, mtime)
+	os.Chtimes(alarm_tmp, mtime, mtime)
+
+	actions, err := RecoverTmpFiles()
+	if err != nil {
+		t.Fatalf("RecoverTmpFiles: %v", err)
+	}
+	if len(actions) != 2 {
+		t.Fatalf("expect 2 actions but %v", actions)
+	}
+	kpi_file, _ := GenerateFileNameAt("KPI", mtime)
+	if _, err := os.Stat(filepath.Join(cfg.AlarmKpiPath, kpi_file)); err != nil {
+		t.Fatalf("KPI tmp file not published: %v", err)
+	}
+	alarm_file, _ := GenerateFileNameAt("WARNING", mtime)
+	if _, err := os.Stat(filepath.Join(cfg.AlarmKpiPath, QUARANTINE_DIR, alarm_file)); err != nil {
+		t.Fatalf("partial alarm tmp file not quarantined: %v", err)
+	}
+	for _, tmp := range []string{kpi_tmp, alarm_tmp} {
+		if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+			t.Fatalf("tmp file [%s] left: %v", tmp, err)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package applog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+/*Routing rule of log records in log_aggregator, a record matches if all the non-empty conditions match*/
+type LogRoute struct {
+	App      string `json:"app"`       //app label
+	MinLevel string `json:"min_level"` //records of the level or above
+	Match    string `json:"match"`     //regex on the log content
+	File     string `json:"file"`      //filename under log_path
+	Continue bool   `json:"continue"`  //keep on matching the following rules, otherwise stop at this one
+}
+
+func (self *LogRoute) Validate() error {
+	if len(self.File) < 1 || strings.ContainsRune(self.File, '/') {
+		return errors.New(fmt.Sprintf("LogRoute: invalid file [%s]", self.File))
+	}
+	if len(self.MinLevel) > 0 && Str2Level(self.MinLevel) == MAX_LEVEL {
+		return errors.New(fmt.Sprintf("LogRoute: invalid min_level [%s]", self.MinLevel))
+	}
+	_, err := regexp.Compile(self.Match)
+	if err != nil {
+		return errors.New(fmt.Sprintf("LogRoute: invalid match [%s]: %v", self.Match, err))
+	}
+	return nil
+}
+
+type compiledRoute struct {
+	LogRoute
+	min_level LOG_LEVEL
+	re        *regexp.Regexp
+	file      *RotatingFile
+}
+
+func (self *compiledRoute) match(rec *LogRecord) bool {
+	if len(self.App) > 0 && rec.App != self.App {
+		return false
+	}
+	if len(self.MinLevel) > 0 {
+		level := Str2Level(rec.Level)
+		if level == MAX_LEVEL || level < self.min_level { //an unknown level is not routed by level
+			return false
+		}
+	}
+	if self.re != nil && !self.re.MatchString(rec.Content()) {
+		return false
+	}
+	return true
+}
+
+/*io.Writer dispatching each log line to the files per the routes, the lines matching no route go to the default file*/
+type LogRouter struct {
+	routes      []compiledRoute
+	def         *RotatingFile
+	files       []*RotatingFile
+	json_format bool
+}
+
+/*Create the router, all the files are under dir and rotated per the policy, the lines are written as JSON if json_format*/
+func NewLogRouter(dir string, default_file string, routes []LogRoute, policy RotatePolicy, json_format bool) (*LogRouter, error) {
+	self := &LogRouter{json_format: json_format}
+	by_name := make(map[string]*RotatingFile)
+	open := func(name string) (*RotatingFile, error) {
+		rf, present := by_name[name]
+		if present {
+			return rf, nil
+		}
+		rf, err := NewRotatingFile(filepath.Join(dir, name), policy)
+		if err != nil {
+			return nil, err
+		}
+		by_name[name] = rf
+		self.files = append(self.files, rf)
+		return rf, nil
+	}
+	def, err := open(default_file)
+	if err != nil {
+		return nil, err
+	}
+	self.def = def
+	for _, r := range routes {
+		err = r.Validate()
+		if err != nil {
+			self.Close()
+			return nil, err
+		}
+		cr := compiledRoute{LogRoute: r, min_level: Str2Level(r.MinLevel)}
+		if len(r.Match) > 0 {
+			cr.re = regexp.MustCompile(r.Match)
+		}
+		cr.file, err = open(r.File)
+		if err != nil {
+			self.Close()
+			return nil, err
+		}
+		self.routes = append(self.routes, cr)
+	}
+	return self, nil
+}
+
+func (self *LogRouter) Write(b []byte) (int, error) {
+	for _, line := range strings.Split(string(b), "\n") {
+		if len(line) < 1 {
+			continue
+		}
+		err := self.writeLine(line)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+func (self *LogRouter) writeLine(line string) error {
+	out := []byte(line + "\n")
+	rec, err := ParseLogLine(line)
+	if err != nil { //not a log line, keep it in the default file
+		_, err = self.def.Write(out)
+		return err
+	}
+	if self.json_format {
+		b, err := json.Marshal(rec)
+		if err == nil {
+			out = append(b, '\n')
+		}
+	}
+	written := make(map[*RotatingFile]bool)
+	for i := range self.routes {
+		r := &self.routes[i]
+		if !r.match(rec) {
+			continue
+		}
+		if !written[r.file] {
+			_, err = r.file.Write(out)
+			if err != nil {
+				return err
+			}
+			written[r.file] = true
+		}
+		if !r.Continue {
+			return nil
+		}
+	}
+	if len(written) == 0 {
+		_, err = self.def.Write(out)
+	}
+	return err
+}
+
+/*Paths of all the routed files, the default one first*/
+func (self *LogRouter) Paths() []string {
+	var paths []string
+	for _, rf := range self.files {
+		paths = append(paths, rf.Path())
+	}
+	return paths
+}
+
+func (self *LogRouter) SetRotateHook(hook func(rotated string)) {
+	for _, rf := range self.files {
+		rf.SetRotateHook(hook)
+	}
+}
+
+/*Flush the files, and rotate those of a passed period even if no line is written in the new period*/
+func (self *LogRouter) Flush() error {
+	for _, rf := range self.files {
+		err := rf.RotateIfDue()
+		if err != nil {
+			return err
+		}
+		err = rf.Flush()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (self *LogRouter) Close() error {
+	for _, rf := range self.files {
+		rf.Close()
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package applog
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogRouter(t *testing.T) {
+	dir := t.TempDir()
+	routes := []LogRoute{
+		{MinLevel: "ERROR", File: "errors.log", Continue: true},
+		{App: "APPLICATION001", File: "application001.log"},
+		{Match: "^db ", File: "db.log"},
+	}
+	router, err := NewLogRouter(dir, "app.log", routes, RotatePolicy{Period: ROTATE_DAY}, false)
+	if err != nil {
+		t.Fatalf("NewLogRouter: %v", err)
+	}
+	router.Write([]byte("20160518-150820.047|APPLICATION001|INFO|info log\n"))
+	router.Write([]byte("20160518-150820.047|APPLICATION001|ERROR|error log\n"))
+	router.Write([]byte("20160518-150820.047|APPLICATION002|FATAL|fatal log\n"))
+	router.Write([]byte("20160518-150820.047|APPLICATION002|INFO|db connected\n"))
+	router.Write([]byte("20160518-150820.047|APPLICATION002|DEBUG|debug log\n"))
+	router.Close()
+
+	expects := map[string][]string{
+		"errors.log":         {"|APPLICATION001|ERROR|error log", "|APPLICATION002|FATAL|fatal log"},
+		"application001.log": {"|APPLICATION001|INFO|info log", "|APPLICATION001|ERROR|error log"},
+		"db.log":             {"|APPLICATION002|INFO|db connected"},
+		"app.log":            {"|APPLICATION002|DEBUG|debug log"},
+	}
+	for name, lines := range expects {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("ReadFile: %v", err)
+		}
+		got := strings.Split(strings.TrimRight(string(b), "\n"), "\n")
+		if len(got) != len(lines) {
+			t.Fatalf("[%s] expect %d lines but %q", name, len(lines), got)
+		}
+		for i := range lines {
+			if !strings.HasSuffix(got[i], lines[i]) {
+				t.Fatalf("[%s] expect [%s] but [%s]", name, lines[i], got[i])
+			}
+		}
+	}
+}
+
+/*clock standing at the time*/
+type fixedClock time.Time
+
+func (self fixedClock) Now() time.Time {
+	return time.Time(self)
+}
+
+func TestLogRouterQuietDay(t *testing.T) {
+	t.Cleanup(func() { SetClock(nil) })
+	SetClock(fixedClock(time.Date(2016, 5, 18, 23, 59, 0, 0, time.Local)))
+	dir := t.TempDir()
+	routes := []LogRoute{{MinLevel: "ERROR", File: "errors.log"}}
+	router, err := NewLogRouter(dir, "app.log", routes, RotatePolicy{Period: ROTATE_DAY}, false)
+	if err != nil {
+		t.Fatalf("NewLogRouter: %v", err)
+	}
+	defer router.Close()
+	router.Write([]byte("20160518-235900.047|APPLICATION001|NOTICE|unknown level\n"))
+	router.Flush()
+
+	//no line is written on the next day, the file is still rotated on the flush
+	SetClock(fixedClock(time.Date(2016, 5, 19, 0, 0, 1, 0, time.Local)))
+	router.Flush()
+	b, err := os.ReadFile(filepath.Join(dir, "app.log.20160518"))
+	if err != nil || !strings.HasSuffix(string(b), "|NOTICE|unknown level\n") {
+		t.Fatalf("unexpected rotated file [%s] %v", string(b), err)
+	}
+	_, err = os.Stat(filepath.Join(dir, "errors.log.20160518"))
+	if err == nil {
+		t.Fatalf("the empty errors.log shall not be rotated")
+	}
+}
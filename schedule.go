@@ -0,0 +1,41 @@
+package applog
+
+import (
+	"time"
+)
+
+/*FlushSchedule tracks the interval boundaries aligned to the epoch, e.g. 00:00, 00:05, 00:10 for 300 seconds*/
+type FlushSchedule struct {
+	interval int64
+	next     int64 //the end of the current interval, the next boundary to flush
+}
+
+/*Create a schedule of the interval in seconds, the first boundary is the end of the interval now is in*/
+func NewFlushSchedule(interval int64, now time.Time) *FlushSchedule {
+	if interval <= 0 {
+		interval = 1
+	}
+	start := now.Unix() - now.Unix()%interval
+	return &FlushSchedule{interval: interval, next: start + interval}
+}
+
+/*Return the next boundary to flush*/
+func (self *FlushSchedule) Next() time.Time {
+	return time.Unix(self.next, 0)
+}
+
+/*Return the start of the current interval*/
+func (self *FlushSchedule) Start() time.Time {
+	return time.Unix(self.next-self.interval, 0)
+}
+
+// Return all the boundaries passed by now in order and advance the schedule,
+// each boundary is returned only once, so a late call gets the missed ones as well
+func (self *FlushSchedule) Due(now time.Time) []time.Time {
+	var due []time.Time
+	for self.next <= now.Unix() {
+		due = append(due, time.Unix(self.next, 0))
+		self.next += self.interval
+	}
+	return due
+}
@@ -0,0 +1,101 @@
+package applog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFlushSchedule(t *testing.T) {
+	now := time.Unix(1000000, 0) //aligned to 100 seconds
+	s := NewFlushSchedule(100, now.Add(30*time.Second))
+	if s.Start() != now || s.Next() != now.Add(100*time.Second) {
+		t.Fatalf("unexpected interval [%v, %v)", s.Start(), s.Next())
+	}
+	if due := s.Due(now.Add(99 * time.Second)); len(due) != 0 {
+		t.Fatalf("nothing shall be due before the boundary: %v", due)
+	}
+	due := s.Due(now.Add(100 * time.Second))
+	if len(due) != 1 || due[0] != now.Add(100*time.Second) {
+		t.Fatalf("expect the boundary due but %v", due)
+	}
+	if due = s.Due(now.Add(100 * time.Second)); len(due) != 0 {
+		t.Fatalf("boundary shall not be due twice: %v", due)
+	}
+	//a late call gets all the missed boundaries
+	due = s.Due(now.Add(350 * time.Second))
+	if len(due) != 2 || due[0] != now.Add(200*time.Second) || due[1] != now.Add(300*time.Second) {
+		t.Fatalf("expect the missed boundaries due but %v", due)
+	}
+}
+
+func TestKpiFlushMissedIntervals(t *testing.T) {
+	cfg := loadTestCfg(t, 7897)
+	kf, err := NewKpiFile()
+	if err != nil {
+		t.Fatalf("NewKpiFile: %v", err)
+	}
+	interval := time.Duration(cfg.KpiInterval) * time.Second
+	//pretend the counters have been accumulating since 3 intervals ago
+	kf.schedule = NewFlushSchedule(cfg.KpiInterval, time.Now().Add(-3*interval))
+	first := kf.schedule.Next()
+	WriteKpi("REQ_COUNT", 5)
+	kf.Process()
+	err = kf.Flush()
+	if err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	for i, expect := range []int64{5, 0, 0} {
+		end := first.Add(time.Duration(i) * interval)
+		filename, _ := GenerateFileNameAt("KPI", end)
+		content, err := os.ReadFile(filepath.Join(cfg.AlarmKpiPath, filename))
+		if err != nil {
+			t.Fatalf("KPI file of interval ending [%v] not written: %v", end, err)
+		}
+		ts := end.Format("20060102150405")
+		line := fmt.Sprintf("%s|kpi_collector|KPI|1.3.1.2.1|%d\n", ts, expect)
+		if string(content[:len(line)]) != line {
+			t.Fatalf("expect [%s] in [%s]", line, string(content))
+		}
+	}
+	if kf.Counters()["1.3.1.2.1"] != 0 || kf.NextFlush().Before(time.Now()) {
+		t.Fatalf("counters not reset or schedule not advanced: %v %v", kf.Counters(), kf.NextFlush())
+	}
+	err = kf.Flush()
+	if err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	files, _ := filepath.Glob(filepath.Join(cfg.AlarmKpiPath, "*-KPI-*"))
+	if len(files) != 3 {
+		t.Fatalf("expect 3 KPI files but %v", files)
+	}
+}
+
+func TestKpiFlushFailedIntervals(t *testing.T) {
+	cfg := loadTestCfg(t, 7911)
+	kf, err := NewKpiFile()
+	if err != nil {
+		t.Fatalf("NewKpiFile: %v", err)
+	}
+	interval := time.Duration(cfg.KpiInterval) * time.Second
+	kf.schedule = NewFlushSchedule(cfg.KpiInterval, time.Now().Add(-3*interval))
+	last := kf.schedule.Next().Add(2 * interval)
+	//a directory in place of the tmp file fails every write
+	blocker := filepath.Join(cfg.AlarmKpiPath, ".kpi.tmp")
+	os.Mkdir(blocker, 0755)
+	err = kf.Flush()
+	if err == nil || !strings.Contains(err.Error(), "failed 3 of 3 intervals") {
+		t.Fatalf("expect every interval tried but %v", err)
+	}
+	if _, end := kf.LastCounters(); end != last {
+		t.Fatalf("expect the last interval ending [%v] flushed but [%v]", last, end)
+	}
+	os.Remove(blocker)
+	err = kf.Flush()
+	if err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package applog
+
+import (
+	"context"
+	"log/slog"
+)
+
+const SLOG_ALARM_KEY = "alarm" //the attribute whose value is taken as alarm name
+
+/*slog.Handler writing records through a Logger, attributes are written as structured fields*/
+type SlogHandler struct {
+	logger *Logger
+	fields []Field
+	alarm  string
+	prefix string //prefix of the attribute keys from WithGroup
+}
+
+/*Create a slog.Handler on the Logger, nil for the default Logger*/
+func NewSlogHandler(l *Logger) *SlogHandler {
+	if l == nil {
+		l = &g_logger
+	}
+	return &SlogHandler{logger: l}
+}
+
+/*Map slog level onto LOG_LEVEL*/
+func SlogLevel(level slog.Level) LOG_LEVEL {
+	switch {
+	case level < slog.LevelInfo:
+		return DEBUG
+	case level < slog.LevelWarn:
+		return INFO
+	case level < slog.LevelError:
+		return WARN
+	default:
+		return ERROR
+	}
+}
+
+func (self *Logger) DebugEnabled() bool {
+	return self.debug.Load()
+}
+
+func (self *SlogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	if SlogLevel(level) == DEBUG {
+		return self.logger.DebugEnabled()
+	}
+	return true
+}
+
+func (self *SlogHandler) Handle(ctx context.Context, r slog.Record) error {
+	fields := make([]Field, len(self.fields), len(self.fields)+r.NumAttrs())
+	copy(fields, self.fields)
+	alarm := self.alarm
+	r.Attrs(func(a slog.Attr) bool {
+		fields = self.appendAttr(fields, &alarm, self.prefix, a)
+		return true
+	})
+	self.logger.write(SlogLevel(r.Level), "", alarm, r.Message, fields)
+	return nil
+}
+
+func (self *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h := *self
+	h.fields = make([]Field, len(self.fields), len(self.fields)+len(attrs))
+	copy(h.fields, self.fields)
+	for _, a := range attrs {
+		h.fields = h.appendAttr(h.fields, &h.alarm, h.prefix, a)
+	}
+	return &h
+}
+
+func (self *SlogHandler) WithGroup(name string) slog.Handler {
+	if len(name) < 1 {
+		return self
+	}
+	h := *self
+	h.prefix = self.prefix + name + "."
+	return &h
+}
+
+func (self *SlogHandler) appendAttr(fields []Field, alarm *string, prefix string, a slog.Attr) []Field {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return fields
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if len(a.Key) > 0 {
+			prefix = prefix + a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			fields = self.appendAttr(fields, alarm, prefix, ga)
+		}
+		return fields
+	}
+	if len(prefix) < 1 && a.Key == SLOG_ALARM_KEY {
+		*alarm = a.Value.String()
+		return fields
+	}
+	return append(fields, Field{prefix + a.Key, a.Value.String()})
+}
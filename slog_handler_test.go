@@ -0,0 +1,39 @@
+package applog
+
+import (
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSlogHandler(t *testing.T) {
+	cfg := &LogCfg{
+		MQID:      7890,
+		Transport: TRANSPORT_MEMORY,
+		LogPath:   t.TempDir(),
+		AlarmOid:  map[string]string{"DB_FAIL": "1.3.1.1.1"},
+	}
+	l, err := NewLogger(cfg, "SLOG001", WithLogFile("mq"))
+	if err != nil {
+		t.Fatalf("NewLogger: %v", err)
+	}
+	defer l.Close()
+	logger := slog.New(NewSlogHandler(l)).With("instance", 1)
+
+	logger.Debug("suppressed debug log")
+	logger.Info("request done", "user", 42, slog.Group("req", "latency_ms", 12))
+	logger.Error("Failed to connect to db", "alarm", "DB_FAIL", "host", "localhost")
+
+	mt := NewMemTransport(cfg.MQID)
+	logs := mt.Drain(LOG_MSG_TYPE)
+	if len(logs) != 2 {
+		t.Fatalf("expect 2 log records but %q", logs)
+	}
+	if !strings.HasSuffix(string(logs[0]), "|SLOG001|INFO|request done|instance=1 user=42 req.latency_ms=12\n") {
+		t.Fatalf("unexpected log record [%s]", string(logs[0]))
+	}
+	alarms := mt.Drain(ALARM_MSG_TYPE)
+	if len(alarms) != 1 || !strings.HasSuffix(string(alarms[0]), "|SLOG001|ERROR|.1.3.1.1.1|Failed to connect to db instance=1 host=localhost") {
+		t.Fatalf("unexpected alarm records %q", alarms)
+	}
+}
@@ -0,0 +1,41 @@
+package applog
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	TRANSPORT_SYSV     = "sysv"
+	TRANSPORT_UNIX     = "unix"
+	TRANSPORT_UNIXGRAM = "unixgram"
+	TRANSPORT_MEMORY   = "memory"
+	DEFAULT_TRANSPORT  = TRANSPORT_SYSV
+)
+
+/*Transport carries log, kpi and alarm records between applications and the
+log_aggregator, the record kind is one of LOG_MSG_TYPE, KPI_MSG_TYPE and ALARM_MSG_TYPE*/
+type Transport interface {
+	/*Send a record of the kind, if nowait is true, return error instead of blocking*/
+	Send(kind int64, rec []byte, nowait bool) error
+	/*Receive a record of the kind, if nowait is true, return error if no record available*/
+	Receive(kind int64, nowait bool) ([]byte, error)
+	Close() error
+}
+
+/*Create the transport selected via the "transport" item of the config*/
+func NewTransport(cfg *LogCfg) (Transport, error) {
+	if cfg == nil {
+		return nil, errors.New("NewTransport failed, config is nil")
+	}
+	switch cfg.Transport {
+	case "", TRANSPORT_SYSV:
+		return NewSysvTransport(cfg.MQID)
+	case TRANSPORT_UNIX, TRANSPORT_UNIXGRAM:
+		return NewUnixTransport(cfg.Transport, cfg.SocketPath)
+	case TRANSPORT_MEMORY:
+		return NewMemTransport(cfg.MQID), nil
+	default:
+		return nil, errors.New(fmt.Sprintf("NewTransport failed, unknown transport [%s]", cfg.Transport))
+	}
+}
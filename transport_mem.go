@@ -0,0 +1,93 @@
+package applog
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+type memQueue struct {
+	recs  map[int64][][]byte
+	refs  int //MemTransports open on the queue, guarded by g_mem_mutex
+	mutex sync.Mutex
+	cond  *sync.Cond
+}
+
+var g_mem_queues = make(map[int64]*memQueue)
+var g_mem_mutex sync.Mutex
+
+/*In-process transport for tests, transports created with the same mq_id share the same queue*/
+type MemTransport struct {
+	q      *memQueue
+	mq_id  int64
+	closed bool //guarded by q.mutex
+}
+
+func NewMemTransport(mq_id int64) *MemTransport {
+	g_mem_mutex.Lock()
+	defer g_mem_mutex.Unlock()
+	q, present := g_mem_queues[mq_id]
+	if !present {
+		q = &memQueue{recs: make(map[int64][][]byte)}
+		q.cond = sync.NewCond(&q.mutex)
+		g_mem_queues[mq_id] = q
+	}
+	q.refs++
+	return &MemTransport{q: q, mq_id: mq_id}
+}
+
+func (self *MemTransport) Send(kind int64, rec []byte, nowait bool) error {
+	b := make([]byte, len(rec))
+	copy(b, rec)
+	self.q.mutex.Lock()
+	defer self.q.mutex.Unlock()
+	self.q.recs[kind] = append(self.q.recs[kind], b)
+	self.q.cond.Broadcast()
+	return nil
+}
+
+func (self *MemTransport) Receive(kind int64, nowait bool) ([]byte, error) {
+	self.q.mutex.Lock()
+	defer self.q.mutex.Unlock()
+	for len(self.q.recs[kind]) == 0 {
+		if self.closed {
+			return nil, errors.New("MemTransport::Receive transport closed")
+		}
+		if nowait {
+			return nil, errors.New(fmt.Sprintf("MemTransport::Receive no record of kind [%d]", kind))
+		}
+		self.q.cond.Wait()
+	}
+	b := self.q.recs[kind][0]
+	self.q.recs[kind] = self.q.recs[kind][1:]
+	return b, nil
+}
+
+/*Remove and return all the pending records of the kind*/
+func (self *MemTransport) Drain(kind int64) [][]byte {
+	self.q.mutex.Lock()
+	defer self.q.mutex.Unlock()
+	recs := self.q.recs[kind]
+	delete(self.q.recs, kind)
+	return recs
+}
+
+/*wake up the Receive blocked on this transport, the queue is released with the last MemTransport of the mq_id*/
+func (self *MemTransport) Close() error {
+	self.q.mutex.Lock()
+	if self.closed {
+		self.q.mutex.Unlock()
+		return nil
+	}
+	self.closed = true
+	self.q.cond.Broadcast()
+	self.q.mutex.Unlock()
+
+	g_mem_mutex.Lock()
+	defer g_mem_mutex.Unlock()
+	self.q.refs--
+	if self.q.refs <= 0 && g_mem_queues[self.mq_id] == self.q {
+		delete(g_mem_queues, self.mq_id)
+	}
+	return nil
+}
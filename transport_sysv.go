@@ -0,0 +1,34 @@
+package applog
+
+import (
+	"sysvipc"
+)
+
+const MAX_REC_SIZE = 1024
+
+/*Transport over the SysV message queue, the record kind is used as msg type*/
+type SysvTransport struct {
+	mq sysvipc.MessageQueue
+}
+
+func NewSysvTransport(mq_id int64) (*SysvTransport, error) {
+	mq, err := sysvipc.GetMsgQueue(mq_id, &sysvipc.MQFlags{true, false, 0660})
+	if err != nil {
+		return nil, err
+	}
+	return &SysvTransport{mq: mq}, nil
+}
+
+func (self *SysvTransport) Send(kind int64, rec []byte, nowait bool) error {
+	return self.mq.Send(kind, rec, &sysvipc.MQSendFlags{nowait})
+}
+
+func (self *SysvTransport) Receive(kind int64, nowait bool) ([]byte, error) {
+	b, _, err := self.mq.Receive(MAX_REC_SIZE, kind, &sysvipc.MQRecvFlags{nowait, true})
+	return b, err
+}
+
+/*the queue is shared with other processes, so leave it in the system*/
+func (self *SysvTransport) Close() error {
+	return nil
+}
@@ -0,0 +1,132 @@
+package applog
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testUnixTransport(t *testing.T, network string) {
+	path := filepath.Join(t.TempDir(), "applog.sock")
+	server, err := NewUnixTransport(network, path)
+	if err != nil {
+		t.Fatalf("NewUnixTransport: %v", err)
+	}
+	defer server.Close()
+	_, err = server.Receive(LOG_MSG_TYPE, true)
+	if err == nil {
+		t.Fatalf("Receive from empty transport shall get error but nil")
+	}
+
+	client, err := NewUnixTransport(network, path)
+	if err != nil {
+		t.Fatalf("NewUnixTransport: %v", err)
+	}
+	defer client.Close()
+	recs := map[int64]string{
+		LOG_MSG_TYPE:   "20160518-150820.047|APPLICATION002|INFO|info log [10238]\n",
+		KPI_MSG_TYPE:   "1.3.1.2.1|100",
+		ALARM_MSG_TYPE: "20160514030208|APPLICATION002|ERROR|.1.3.1.1.1|Failed to connect to db",
+	}
+	for kind, rec := range recs {
+		err = client.Send(kind, []byte(rec), true)
+		if err != nil {
+			t.Fatalf("Send [%d]: %v", kind, err)
+		}
+	}
+	for kind, rec := range recs {
+		b, err := server.Receive(kind, false)
+		if err != nil {
+			t.Fatalf("Receive [%d]: %v", kind, err)
+		}
+		if string(b) != rec {
+			t.Fatalf("Receive [%d] expect [%s] but [%s]", kind, rec, string(b))
+		}
+	}
+}
+
+func TestUnixgramTransport(t *testing.T) {
+	testUnixTransport(t, TRANSPORT_UNIXGRAM)
+}
+
+func TestUnixStreamTransport(t *testing.T) {
+	testUnixTransport(t, TRANSPORT_UNIX)
+}
+
+func TestUnixTransportSocketClosed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "applog.sock")
+	tr, err := NewUnixTransport(TRANSPORT_UNIXGRAM, path)
+	if err != nil {
+		t.Fatalf("NewUnixTransport: %v", err)
+	}
+	defer tr.Close()
+	pc, err := net.ListenUnixgram(TRANSPORT_UNIXGRAM, &net.UnixAddr{Name: path, Net: TRANSPORT_UNIXGRAM})
+	if err != nil {
+		t.Fatalf("ListenUnixgram: %v", err)
+	}
+	exited := make(chan struct{})
+	go func() {
+		tr.readDatagrams(pc)
+		close(exited)
+	}()
+	pc.Close()
+	select {
+	case <-exited:
+	case <-time.After(time.Second):
+		t.Fatalf("readDatagrams keeps reading the closed socket")
+	}
+}
+
+func TestUnixTransportBind(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "applog.sock")
+	//the stale socket left by last run is replaced
+	pc, err := net.ListenUnixgram(TRANSPORT_UNIXGRAM, &net.UnixAddr{Name: path, Net: TRANSPORT_UNIXGRAM})
+	if err != nil {
+		t.Fatalf("ListenUnixgram: %v", err)
+	}
+	pc.Close()
+	server, _ := NewUnixTransport(TRANSPORT_UNIXGRAM, path)
+	defer server.Close()
+	err = server.bind()
+	if err != nil {
+		t.Fatalf("bind on stale socket: %v", err)
+	}
+	//the socket of a running server is not taken over
+	second, _ := NewUnixTransport(TRANSPORT_UNIXGRAM, path)
+	defer second.Close()
+	if second.bind() == nil {
+		t.Fatalf("bind took over the socket in use")
+	}
+
+	client, _ := NewUnixTransport(TRANSPORT_UNIXGRAM, path)
+	client.Close()
+	if client.Send(LOG_MSG_TYPE, []byte("after close"), true) == nil || client.conn != nil {
+		t.Fatalf("Send after Close shall fail without dialing")
+	}
+}
+
+func TestMemTransportClose(t *testing.T) {
+	tr := NewMemTransport(7908)
+	received := make(chan error)
+	go func() {
+		_, err := tr.Receive(LOG_MSG_TYPE, false)
+		received <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	tr.Close()
+	select {
+	case err := <-received:
+		if err == nil {
+			t.Fatalf("Receive of the closed transport shall get error but nil")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Close does not wake up the blocked Receive")
+	}
+	g_mem_mutex.Lock()
+	_, present := g_mem_queues[7908]
+	g_mem_mutex.Unlock()
+	if present {
+		t.Fatalf("the queue is not released with the last MemTransport")
+	}
+}
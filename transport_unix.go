@@ -0,0 +1,274 @@
+package applog
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	UNIX_SEND_TIMEOUT   = 10 * time.Millisecond //how long a nowait Send may wait for the socket buffer
+	UNIX_QUEUE_SIZE     = 4096                  //records buffered per kind at the listening side
+	UNIX_MAX_REC_SIZE   = 64 * 1024
+	UNIX_RETRY_INTERVAL = 100 * time.Millisecond //backoff after a failed read or accept
+)
+
+/*Transport over a Unix domain socket, network is "unixgram" or "unix"(stream).
+The applications dial the socket on the first Send, the log_aggregator binds
+the socket on the first Receive.
+Each datagram is [kind:1][record], each stream frame is [kind:1][length:4][record]*/
+type UnixTransport struct {
+	network string
+	path    string
+	conn    net.Conn
+	bound   bool
+	closer  io.Closer
+	queues  map[int64]chan []byte
+	done    chan struct{}
+	mutex   sync.Mutex
+}
+
+func NewUnixTransport(network string, path string) (*UnixTransport, error) {
+	if network != TRANSPORT_UNIX && network != TRANSPORT_UNIXGRAM {
+		return nil, errors.New(fmt.Sprintf("NewUnixTransport: invalid network [%s]", network))
+	}
+	if len(path) < 1 {
+		return nil, errors.New("NewUnixTransport: empty socket path")
+	}
+	queues := make(map[int64]chan []byte)
+	for _, kind := range []int64{KPI_MSG_TYPE, ALARM_MSG_TYPE, LOG_MSG_TYPE} {
+		queues[kind] = make(chan []byte, UNIX_QUEUE_SIZE)
+	}
+	return &UnixTransport{
+		network: network,
+		path:    path,
+		queues:  queues,
+		done:    make(chan struct{}),
+	}, nil
+}
+
+func (self *UnixTransport) Send(kind int64, rec []byte, nowait bool) error {
+	if kind < 0 || kind > 255 {
+		return errors.New(fmt.Sprintf("UnixTransport::Send invalid kind [%d]", kind))
+	}
+	if len(rec) > UNIX_MAX_REC_SIZE {
+		rec = rec[:UNIX_MAX_REC_SIZE]
+	}
+	var frame []byte
+	if self.network == TRANSPORT_UNIXGRAM {
+		frame = make([]byte, 1+len(rec))
+		frame[0] = byte(kind)
+		copy(frame[1:], rec)
+	} else {
+		frame = make([]byte, 5+len(rec))
+		frame[0] = byte(kind)
+		binary.BigEndian.PutUint32(frame[1:5], uint32(len(rec)))
+		copy(frame[5:], rec)
+	}
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	select {
+	case <-self.done:
+		return errors.New("UnixTransport::Send transport closed")
+	default:
+	}
+	if self.conn == nil {
+		conn, err := net.Dial(self.network, self.path)
+		if err != nil {
+			return errors.New(fmt.Sprintf("UnixTransport::Send dial [%s] failed: %v", self.path, err))
+		}
+		self.conn = conn
+	}
+	if nowait {
+		self.conn.SetWriteDeadline(time.Now().Add(UNIX_SEND_TIMEOUT))
+	} else {
+		self.conn.SetWriteDeadline(time.Time{})
+	}
+	_, err := self.conn.Write(frame)
+	if err != nil {
+		//a partial frame breaks the stream, so always redial on next Send
+		self.conn.Close()
+		self.conn = nil
+		return errors.New(fmt.Sprintf("UnixTransport::Send failed: %v", err))
+	}
+	return nil
+}
+
+func (self *UnixTransport) Receive(kind int64, nowait bool) ([]byte, error) {
+	q, present := self.queues[kind]
+	if !present {
+		return nil, errors.New(fmt.Sprintf("UnixTransport::Receive invalid kind [%d]", kind))
+	}
+	err := self.bind()
+	if err != nil {
+		return nil, err
+	}
+	if nowait {
+		select {
+		case b := <-q:
+			return b, nil
+		case <-self.done:
+			return nil, errors.New("UnixTransport::Receive transport closed")
+		default:
+			return nil, errors.New("UnixTransport::Receive no record")
+		}
+	}
+	select {
+	case b := <-q:
+		return b, nil
+	case <-self.done:
+		return nil, errors.New("UnixTransport::Receive transport closed")
+	}
+}
+
+func (self *UnixTransport) Close() error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	select {
+	case <-self.done:
+		return nil
+	default:
+	}
+	close(self.done)
+	if self.conn != nil {
+		self.conn.Close()
+		self.conn = nil
+	}
+	if self.bound {
+		self.closer.Close()
+		os.Remove(self.path)
+	}
+	return nil
+}
+
+/*bind the socket and launch the reader routine if not yet*/
+func (self *UnixTransport) bind() error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.bound {
+		return nil
+	}
+	select {
+	case <-self.done:
+		return errors.New("UnixTransport::bind transport closed")
+	default:
+	}
+	//remove the stale socket left by last run, but never take over the socket of a running log_aggregator
+	conn, err := net.Dial(self.network, self.path)
+	if err == nil {
+		conn.Close()
+		return errors.New(fmt.Sprintf("UnixTransport::bind [%s] failed: socket in use", self.path))
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		os.Remove(self.path)
+	}
+	if self.network == TRANSPORT_UNIXGRAM {
+		pc, err := net.ListenUnixgram(self.network, &net.UnixAddr{Name: self.path, Net: self.network})
+		if err != nil {
+			return errors.New(fmt.Sprintf("UnixTransport::bind [%s] failed: %v", self.path, err))
+		}
+		self.closer = pc
+		go self.readDatagrams(pc)
+	} else {
+		ln, err := net.Listen(self.network, self.path)
+		if err != nil {
+			return errors.New(fmt.Sprintf("UnixTransport::bind [%s] failed: %v", self.path, err))
+		}
+		self.closer = ln
+		go self.acceptStreams(ln)
+	}
+	os.Chmod(self.path, 0660)
+	self.bound = true
+	return nil
+}
+
+/*read the datagrams until the socket is closed, back off on other errors*/
+func (self *UnixTransport) readDatagrams(pc *net.UnixConn) {
+	buf := make([]byte, UNIX_MAX_REC_SIZE+1)
+	for {
+		n, err := pc.Read(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) || self.backoff() {
+				return
+			}
+			continue
+		}
+		if n < 1 {
+			continue
+		}
+		rec := make([]byte, n-1)
+		copy(rec, buf[1:n])
+		if !self.dispatch(int64(buf[0]), rec) {
+			return
+		}
+	}
+}
+
+func (self *UnixTransport) acceptStreams(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) || self.backoff() {
+				return
+			}
+			continue
+		}
+		go self.readStream(conn)
+	}
+}
+
+func (self *UnixTransport) readStream(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	header := make([]byte, 5)
+	for {
+		_, err := io.ReadFull(r, header)
+		if err != nil {
+			return
+		}
+		l := binary.BigEndian.Uint32(header[1:5])
+		if l > UNIX_MAX_REC_SIZE {
+			return //corrupted stream, drop the connection
+		}
+		rec := make([]byte, l)
+		_, err = io.ReadFull(r, rec)
+		if err != nil {
+			return
+		}
+		if !self.dispatch(int64(header[0]), rec) {
+			return
+		}
+	}
+}
+
+/*wait before retrying a failed read, return true if transport closed*/
+func (self *UnixTransport) backoff() bool {
+	select {
+	case <-self.done:
+		return true
+	case <-time.After(UNIX_RETRY_INTERVAL):
+		return false
+	}
+}
+
+/*put the record into the queue of its kind, return false if transport closed*/
+func (self *UnixTransport) dispatch(kind int64, rec []byte) bool {
+	q, present := self.queues[kind]
+	if !present {
+		return true //unknown kind, drop it
+	}
+	select {
+	case q <- rec:
+		return true
+	case <-self.done:
+		return false
+	}
+}
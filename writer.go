@@ -0,0 +1,73 @@
+package applog
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"sync"
+)
+
+const MAX_WRITER_LINE = 64 * 1024 //a partial line longer than this is written without waiting for the line break
+
+/*io.Writer turning each written line into a log record at the level and app label*/
+type LogWriter struct {
+	logger *Logger
+	level  LOG_LEVEL
+	app    string
+	buf    []byte
+	mutex  sync.Mutex
+}
+
+/*Create a LogWriter on the Logger, nil for the default Logger, empty app_label to use the AppName of the Logger*/
+func NewLogWriter(l *Logger, level LOG_LEVEL, app_label string) *LogWriter {
+	if l == nil {
+		l = &g_logger
+	}
+	return &LogWriter{logger: l, level: level, app: app_label}
+}
+
+// Redirect the output of the standard log package to a LogWriter, the timestamp flags are cleared
+// as the log record has its own
+func RedirectStdLog(l *Logger, level LOG_LEVEL, app_label string) *LogWriter {
+	w := NewLogWriter(l, level, app_label)
+	log.SetFlags(0)
+	log.SetOutput(w)
+	return w
+}
+
+func (self *LogWriter) Write(b []byte) (int, error) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.buf = append(self.buf, b...)
+	for {
+		i := bytes.IndexByte(self.buf, '\n')
+		if i < 0 {
+			break
+		}
+		self.writeLine(string(self.buf[:i]))
+		self.buf = self.buf[i+1:]
+	}
+	if len(self.buf) > MAX_WRITER_LINE {
+		self.writeLine(string(self.buf))
+		self.buf = nil
+	}
+	return len(b), nil
+}
+
+/*Write the pending partial line*/
+func (self *LogWriter) Flush() {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if len(self.buf) > 0 {
+		self.writeLine(string(self.buf))
+		self.buf = nil
+	}
+}
+
+func (self *LogWriter) writeLine(line string) {
+	line = strings.TrimRight(line, "\r")
+	if len(line) < 1 {
+		return
+	}
+	self.logger.write(self.level, self.app, NO_ALARM, line, nil)
+}
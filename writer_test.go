@@ -0,0 +1,47 @@
+package applog
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLogWriter(t *testing.T) {
+	cfg := &LogCfg{
+		MQID:      7891,
+		Transport: TRANSPORT_MEMORY,
+		LogPath:   t.TempDir(),
+	}
+	l, err := NewLogger(cfg, "APPLICATION001", WithLogFile("mq"))
+	if err != nil {
+		t.Fatalf("NewLogger: %v", err)
+	}
+	defer l.Close()
+
+	w := NewLogWriter(l, WARN, "THIRDPARTY")
+	fmt.Fprintf(w, "first line\r\nsecond ")
+	fmt.Fprintf(w, "line\n\npartial")
+	w.Flush()
+
+	RedirectStdLog(l, INFO, "")
+	defer log.SetOutput(os.Stderr)
+	log.Printf("from std log %d", 1)
+
+	logs := NewMemTransport(cfg.MQID).Drain(LOG_MSG_TYPE)
+	expects := []string{
+		"|THIRDPARTY|WARN|first line\n",
+		"|THIRDPARTY|WARN|second line\n",
+		"|THIRDPARTY|WARN|partial\n",
+		"|APPLICATION001|INFO|from std log 1\n",
+	}
+	if len(logs) != len(expects) {
+		t.Fatalf("expect %d log records but %q", len(expects), logs)
+	}
+	for i, expect := range expects {
+		if !strings.HasSuffix(string(logs[i]), expect) {
+			t.Fatalf("expect [%s] but [%s]", expect, string(logs[i]))
+		}
+	}
+}